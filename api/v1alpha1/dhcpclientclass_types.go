@@ -0,0 +1,136 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/bmcgo/k8s-dhcp/dhcp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DHCPClientClassSpec defines the desired state of DHCPClientClass
+type DHCPClientClassSpec struct {
+	// Priority of the class. Parameters of class with higher priority override parameters of lower ones
+	Priority int              `json:"priority,omitempty"`
+	Match    ClientClassMatch `json:"match"`
+	Options  []Option         `json:"options,omitempty"`
+	// BootFileName overrides subnet boot file name and boot profiles
+	BootFileName string `json:"bootFileName,omitempty"`
+	LeaseTime    int    `json:"leaseTime,omitempty"`
+	// RangeFrom and RangeTo restrict pool new addresses are allocated from for clients of the class.
+	// Restriction applies to subnets which range contains the pool
+	RangeFrom string `json:"rangeFrom,omitempty"`
+	RangeTo   string `json:"rangeTo,omitempty"`
+	// VendorOptions are sub-options of vendor specific information (option 43), they take precedence over
+	// subnet ones
+	VendorOptions []VendorOptions `json:"vendorOptions,omitempty"`
+	// EnterpriseOptions are sub-options of vendor-identifying vendor-specific information (option 125),
+	// they take precedence over subnet ones
+	EnterpriseOptions []EnterpriseOptions `json:"enterpriseOptions,omitempty"`
+}
+
+// ClientClassMatch defines conditions of the class. Client matches if every non-empty condition
+// matches any of its values
+type ClientClassMatch struct {
+	// VendorClass is a list of vendor class identifier (option 60) prefixes, e.g. "PXEClient"
+	VendorClass []string `json:"vendorClass,omitempty"`
+	// UserClass is a list of user classes (option 77), e.g. "iPXE"
+	UserClass []string `json:"userClass,omitempty"`
+	// Arch is a list of client architectures (option 93), e.g. "bios", "efi-x64" or a number
+	Arch []string `json:"arch,omitempty"`
+	// MACPrefix is a list of hardware address prefixes, e.g. OUI "52:54:00"
+	MACPrefix []string `json:"macPrefix,omitempty"`
+	// CircuitID is a list of relay agent circuit ids, printable or colon separated hex
+	CircuitID []string `json:"circuitId,omitempty"`
+	// RemoteID is a list of relay agent remote ids, printable or colon separated hex
+	RemoteID []string `json:"remoteId,omitempty"`
+	// HostName is a list of host name (option 12) patterns, e.g. "node-*"
+	HostName []string `json:"hostname,omitempty"`
+	// Expression is CEL expression evaluated against the request, e.g. "options[77] == b'iPXE'"
+	Expression string `json:"expression,omitempty"`
+}
+
+// DHCPClientClassStatus defines the observed state of DHCPClientClass
+type DHCPClientClassStatus struct {
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="priority",type="integer",JSONPath=".spec.priority",description="Priority",priority=0
+
+// DHCPClientClass is the Schema for the dhcpclientclasses API
+type DHCPClientClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DHCPClientClassSpec   `json:"spec,omitempty"`
+	Status DHCPClientClassStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DHCPClientClassList contains a list of DHCPClientClass
+type DHCPClientClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DHCPClientClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DHCPClientClass{}, &DHCPClientClassList{})
+}
+
+// ToClientClass converts object to dhcp.ClientClass. Error is returned if options, architectures or
+// expression are invalid
+func (s *DHCPClientClass) ToClientClass() (dhcp.ClientClass, error) {
+	m := s.Spec.Match
+	class := dhcp.ClientClass{
+		Name:     s.Name,
+		Priority: s.Spec.Priority,
+		Match: dhcp.ClientClassMatch{
+			VendorClass: m.VendorClass,
+			UserClass:   m.UserClass,
+			MACPrefix:   append([]string{}, m.MACPrefix...),
+			CircuitID:   m.CircuitID,
+			RemoteID:    m.RemoteID,
+			HostName:    m.HostName,
+		},
+		BootFileName: s.Spec.BootFileName,
+		LeaseTime:    s.Spec.LeaseTime,
+		RangeFrom:    s.Spec.RangeFrom,
+		RangeTo:      s.Spec.RangeTo,
+	}
+	for _, name := range m.Arch {
+		arch, err := dhcp.ParseArch(name)
+		if err != nil {
+			return class, err
+		}
+		class.Match.Arch = append(class.Match.Arch, arch)
+	}
+	var err error
+	class.Match.Expression, err = dhcp.CompileMatchExpression(m.Expression)
+	if err != nil {
+		return class, err
+	}
+	class.VendorOptions, class.EnterpriseOptions, err = toVendorOptions(s.Spec.VendorOptions,
+		s.Spec.EnterpriseOptions)
+	if err != nil {
+		return class, err
+	}
+	class.Options, err = toOptions(s.Spec.Options)
+	return class, err
+}
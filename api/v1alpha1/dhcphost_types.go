@@ -17,8 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"errors"
 	"github.com/bmcgo/k8s-dhcp/dhcp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"net"
 )
 
@@ -28,7 +30,7 @@ import (
 // DHCPHostSpec defines the desired state of DHCPHost
 type DHCPHostSpec struct {
 	Subnet         string   `json:"subnet"`
-	MAC            string   `json:"mac"`
+	MAC            string   `json:"mac,omitempty"`
 	IP             string   `json:"ip,omitempty"`
 	Gateway        string   `json:"gateway,omitempty"`
 	HostName       string   `json:"hostname,omitempty"`
@@ -37,12 +39,35 @@ type DHCPHostSpec struct {
 	ServerHostName string   `json:"serverHostName,omitempty"`
 	BootFileName   string   `json:"bootFileName,omitempty"`
 	LeaseTime      int      `json:"leaseTime,omitempty"`
+	// ClientID is client identifier (option 61) in hex, e.g. "01:52:54:00:12:34:56".
+	// Reservation is keyed by client id if set
+	ClientID string `json:"clientId,omitempty"`
+	// DUID is DHCPv6 client DUID in hex, e.g. "00:03:00:01:52:54:00:12:34:56"
+	DUID string `json:"duid,omitempty"`
+	// DelegatedPrefix is DHCPv6 prefix reserved for the host, e.g. "2001:db8:100:100::/56"
+	DelegatedPrefix string `json:"delegatedPrefix,omitempty"`
+	// BootProfiles select boot parameters by client architecture and iPXE user class.
+	// Subnet boot profiles are used if neither bootFileName nor bootProfiles are set
+	BootProfiles []BootProfile `json:"bootProfiles,omitempty"`
+	// HTTPBootURL is boot file URL for UEFI HTTP Boot clients (vendor class "HTTPClient")
+	HTTPBootURL string `json:"httpBootURL,omitempty"`
+	// IPXEScript is name of the template iPXE client is pointed to at HTTP boot endpoint of this server.
+	// Subnet script is used if neither boot parameters nor script are set
+	//+kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	IPXEScript string `json:"ipxeScript,omitempty"`
+	// BootParams are passed to HTTP boot templates of the host, overriding subnet parameters with the same name
+	BootParams map[string]string `json:"bootParams,omitempty"`
+	// RenewalTime is T1 (option 58): number of seconds, or percentage of lease time, e.g. "25%".
+	// Subnet renewal time is used if not set
+	RenewalTime *intstr.IntOrString `json:"renewalTime,omitempty"`
+	// RebindingTime is T2 (option 59): number of seconds, or percentage of lease time, e.g. "87.5%".
+	// Subnet rebinding time is used if not set
+	RebindingTime *intstr.IntOrString `json:"rebindingTime,omitempty"`
 }
 
 // DHCPHostStatus defines the observed state of DHCPHost
 type DHCPHostStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	ErrorMessage string `json:"errorMessage,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -69,26 +94,57 @@ type DHCPHostList struct {
 	Items           []DHCPHost `json:"items"`
 }
 
-func (s *DHCPHost) ToDHCPHost() dhcp.Host {
+// ToDHCPHost converts object to dhcp.Host. Error is returned if options or client id are invalid
+func (s *DHCPHost) ToDHCPHost() (dhcp.Host, error) {
+	if s.Spec.MAC == "" && s.Spec.ClientID == "" && s.Spec.DUID == "" {
+		return dhcp.Host{}, errors.New("either mac, clientId or duid is required")
+	}
+	clientID, err := dhcp.ParseClientID(s.Spec.ClientID)
+	if err != nil {
+		return dhcp.Host{}, err
+	}
+	duid, err := dhcp.ParseClientID(s.Spec.DUID)
+	if err != nil {
+		return dhcp.Host{}, err
+	}
+	var prefix *net.IPNet
+	if s.Spec.DelegatedPrefix != "" {
+		_, prefix, err = net.ParseCIDR(s.Spec.DelegatedPrefix)
+		if err != nil {
+			return dhcp.Host{}, err
+		}
+	}
 	host := dhcp.Host{
 		MAC:            s.Spec.MAC,
+		ClientID:       clientID,
+		DUID:           duid,
 		IP:             net.ParseIP(s.Spec.IP),
+		Prefix:         prefix,
 		Gateway:        net.ParseIP(s.Spec.Gateway),
 		ServerHostName: s.Spec.ServerHostName,
 		BootFileName:   s.Spec.BootFileName,
+		HTTPBootURL:    s.Spec.HTTPBootURL,
+		IPXEScript:     s.Spec.IPXEScript,
+		BootParams:     s.Spec.BootParams,
 		LeaseTime:      s.Spec.LeaseTime,
 		HostName:       s.Spec.HostName,
-		Options:        []dhcp.Option{},
 		DNS:            s.Spec.DNS,
 	}
-	for _, opt := range s.Spec.Options {
-		host.Options = append(host.Options, dhcp.Option{
-			ID:    opt.ID,
-			Type:  opt.Type,
-			Value: opt.Value,
-		})
+	host.RenewalTime, host.RebindingTime, err = toTimers(s.Spec.RenewalTime, s.Spec.RebindingTime,
+		s.Spec.LeaseTime)
+	if err != nil {
+		return host, err
+	}
+	host.BootProfiles, err = toBootProfiles(s.Spec.BootProfiles)
+	if err != nil {
+		return host, err
+	}
+	err = validateHTTPBootURL(s.Spec.HTTPBootURL)
+	if err != nil {
+		return host, err
 	}
-	return host
+	host.Options, err = toOptions(s.Spec.Options)
+	return host, err
 }
 
 func init() {
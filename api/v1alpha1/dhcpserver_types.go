@@ -17,8 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
 	"github.com/bmcgo/k8s-dhcp/dhcp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
 )
 
 // DHCPServerSpec defines the desired state of DHCPServer
@@ -26,6 +28,38 @@ type DHCPServerSpec struct {
 	ListenInterface string `json:"listenInterface,omitempty"`
 	ListenAddress   string `json:"listenAddress,omitempty"`
 	ReuseAddr       bool   `json:"reuseAddr,omitempty"`
+	// Protocol served by the listener. Default is dhcpv4
+	//+kubebuilder:validation:Enum=dhcpv4;dhcpv6
+	Protocol string `json:"protocol,omitempty"`
+	// Relay makes the listener DHCPv4 relay agent (RFC 3046) forwarding requests of clients on listenInterface
+	// to upstream servers instead of serving them
+	Relay *Relay `json:"relay,omitempty"`
+	// ProxyDHCP makes the listener proxyDHCP server for PXE clients on networks where addresses are assigned
+	// by another DHCP server. Boot server discovery is served on port 4011 of listenAddress
+	ProxyDHCP *ProxyDHCP `json:"proxyDHCP,omitempty"`
+}
+
+type Relay struct {
+	// Upstreams are addresses of DHCP servers requests are relayed to, e.g. "10.0.0.1" or "10.0.0.1:67".
+	// Requests to addresses of this server are processed in place
+	//+kubebuilder:validation:MinItems=1
+	Upstreams []string `json:"upstreams"`
+	// GatewayAddress is giaddr of relayed requests, the first address of listenInterface by default
+	GatewayAddress string `json:"gatewayAddress,omitempty"`
+	// CircuitID is Go template of agent circuit id (option 82 sub-option 1), e.g. "{{.Interface}}".
+	// Available fields are Interface, MAC, GatewayAddress and Hostname
+	CircuitID string `json:"circuitId,omitempty"`
+	// RemoteID is Go template of agent remote id (option 82 sub-option 2), e.g. "{{.Hostname}}"
+	RemoteID string `json:"remoteId,omitempty"`
+}
+
+type ProxyDHCP struct {
+	BootFileName string `json:"bootFileName,omitempty"`
+	// BootProfiles select boot parameters by client architecture and iPXE user class. First matching profile
+	// is used, bootFileName is used if none matches
+	BootProfiles []BootProfile `json:"bootProfiles,omitempty"`
+	// ServerAddress is next server (siaddr) and server identifier, the address request is received at by default
+	ServerAddress string `json:"serverAddress,omitempty"`
 }
 
 // DHCPServerStatus defines the observed state of DHCPServer
@@ -61,10 +95,35 @@ func init() {
 	SchemeBuilder.Register(&DHCPServer{}, &DHCPServerList{})
 }
 
-func (s *DHCPServer) ToListen() dhcp.Listen {
-	return dhcp.Listen{
+func (s *DHCPServer) ToListen() (dhcp.Listen, error) {
+	var err error
+	listen := dhcp.Listen{
 		Name:      s.Name,
 		Interface: s.Spec.ListenInterface,
 		Addr:      s.Spec.ListenAddress,
+		Protocol:  s.Spec.Protocol,
+	}
+	if s.Spec.Relay != nil {
+		listen.Relay = &dhcp.RelayConfig{
+			Upstreams:      s.Spec.Relay.Upstreams,
+			GatewayAddress: s.Spec.Relay.GatewayAddress,
+			CircuitID:      s.Spec.Relay.CircuitID,
+			RemoteID:       s.Spec.Relay.RemoteID,
+		}
+	}
+	if s.Spec.ProxyDHCP != nil {
+		proxy := &dhcp.ProxyDHCPConfig{BootFileName: s.Spec.ProxyDHCP.BootFileName}
+		proxy.BootProfiles, err = toBootProfiles(s.Spec.ProxyDHCP.BootProfiles)
+		if err != nil {
+			return listen, err
+		}
+		if s.Spec.ProxyDHCP.ServerAddress != "" {
+			proxy.ServerAddress = net.ParseIP(s.Spec.ProxyDHCP.ServerAddress).To4()
+			if proxy.ServerAddress == nil {
+				return listen, fmt.Errorf("invalid server address %q", s.Spec.ProxyDHCP.ServerAddress)
+			}
+		}
+		listen.ProxyDHCP = proxy
 	}
+	return listen, nil
 }
@@ -17,10 +17,17 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
 	"github.com/bmcgo/k8s-dhcp/dhcp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"net"
+	"net/url"
+	"time"
 )
 
+const defaultProbeTimeout = time.Millisecond * 500
+
 // DHCPSubnetSpec defines the desired state of DHCPSubnet
 type DHCPSubnetSpec struct {
 	Subnet         string   `json:"subnet"`
@@ -32,25 +39,265 @@ type DHCPSubnetSpec struct {
 	ServerHostName string   `json:"serverHostName,omitempty"`
 	BootFileName   string   `json:"bootFileName,omitempty"`
 	LeaseTime      int      `json:"leaseTime,omitempty"`
+	// MinLeaseTime and MaxLeaseTime are limits of lease time clients may request (option 51). Requested lease
+	// time is ignored if neither is set, unset limit defaults to leaseTime
+	MinLeaseTime int `json:"minLeaseTime,omitempty"`
+	MaxLeaseTime int `json:"maxLeaseTime,omitempty"`
+	// RenewalTime is T1 (option 58): number of seconds, or percentage of lease time, e.g. "25%"
+	RenewalTime *intstr.IntOrString `json:"renewalTime,omitempty"`
+	// RebindingTime is T2 (option 59): number of seconds, or percentage of lease time, e.g. "87.5%"
+	RebindingTime *intstr.IntOrString `json:"rebindingTime,omitempty"`
+	// DeclineQuarantineTime is a number of seconds address declined by a client is not offered to anybody
+	DeclineQuarantineTime int `json:"declineQuarantineTime,omitempty"`
+	// ClientIDPolicy defines how leases are bound to clients: by MAC, by client identifier (option 61)
+	// or by client identifier taking over lease bound to MAC. Default is mac
+	//+kubebuilder:validation:Enum=mac;client-id;client-id-with-mac-fallback
+	ClientIDPolicy string `json:"clientIdPolicy,omitempty"`
+	// RapidCommit enables two-message exchange (RFC 4039): DISCOVER with rapid commit option is answered with ACK
+	RapidCommit bool `json:"rapidCommit,omitempty"`
+	// PrefixDelegation defines DHCPv6 pool of prefixes delegated to requesting routers (RFC 8415 IA_PD)
+	PrefixDelegation *PrefixDelegation `json:"prefixDelegation,omitempty"`
+	// BootProfiles select boot parameters by client architecture and iPXE user class. First matching profile
+	// is used, bootFileName is used if none matches
+	BootProfiles []BootProfile `json:"bootProfiles,omitempty"`
+	// HTTPBootURL is boot file URL for UEFI HTTP Boot clients (vendor class "HTTPClient"),
+	// e.g. "https://10.0.1.2/ipxe.efi"
+	HTTPBootURL string `json:"httpBootURL,omitempty"`
+	// EmbeddedTFTP sets next server (siaddr) to the address of this server, so boot files are loaded from
+	// its embedded TFTP server. Subnet is rejected if the TFTP server is disabled
+	EmbeddedTFTP bool `json:"embeddedTFTP,omitempty"`
+	// IPXEScript is name of the template iPXE clients are pointed to at HTTP boot endpoint of this server,
+	// e.g. "boot.ipxe". Firmware PXE clients still get bootFileName or boot profiles to chainload iPXE
+	//+kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	IPXEScript string `json:"ipxeScript,omitempty"`
+	// BootParams are passed to HTTP boot templates of clients of the subnet, e.g. kernel arguments
+	BootParams map[string]string `json:"bootParams,omitempty"`
+	// MatchExpression is CEL expression evaluated against DHCPv4 request. Requests for which it is false
+	// are ignored, e.g. "vendorClass.startsWith('PXEClient')"
+	MatchExpression string `json:"matchExpression,omitempty"`
+	// ConflictProbe enables probing new addresses before offering them: ARP for directly attached clients,
+	// ICMP echo for relayed ones. Address which answered is quarantined like declined one
+	ConflictProbe *ConflictProbe `json:"conflictProbe,omitempty"`
+	// VendorOptions are sub-options of vendor specific information (option 43) by client vendor class
+	VendorOptions []VendorOptions `json:"vendorOptions,omitempty"`
+	// EnterpriseOptions are sub-options of vendor-identifying vendor-specific information (option 125)
+	// by enterprise number
+	EnterpriseOptions []EnterpriseOptions `json:"enterpriseOptions,omitempty"`
 
 	Server metav1.OwnerReference `json:"server,omitempty"`
 }
 
+type PrefixDelegation struct {
+	// Prefix is a parent prefix delegated prefixes are allocated from, e.g. "2001:db8:100::/40"
+	Prefix string `json:"prefix"`
+	// DelegatedLength is a length of delegated prefixes, e.g. 56
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=128
+	DelegatedLength int `json:"delegatedLength"`
+}
+
+type BootProfile struct {
+	// Arch is a list of client architectures (option 93), e.g. "bios", "efi-x64", "efi-arm64" or a number.
+	// Profile matches any architecture if empty, except of UEFI HTTP Boot clients
+	Arch []string `json:"arch,omitempty"`
+	// IPXE profile matches only iPXE clients (user class "iPXE"), and profile without it matches only
+	// firmware PXE clients, so iPXE gets its script instead of chainloading itself again
+	IPXE           bool   `json:"ipxe,omitempty"`
+	BootFileName   string `json:"bootFileName,omitempty"`
+	NextServer     string `json:"nextServer,omitempty"`
+	TFTPServerName string `json:"tftpServerName,omitempty"`
+}
+
+// toBootProfiles converts boot profiles to dhcp.BootProfile. Error is returned if architecture is unknown
+func toBootProfiles(profiles []BootProfile) ([]dhcp.BootProfile, error) {
+	var result []dhcp.BootProfile
+	for _, p := range profiles {
+		profile := dhcp.BootProfile{
+			IPXE:           p.IPXE,
+			BootFileName:   p.BootFileName,
+			TFTPServerName: p.TFTPServerName,
+		}
+		for _, name := range p.Arch {
+			arch, err := dhcp.ParseArch(name)
+			if err != nil {
+				return nil, err
+			}
+			profile.Arch = append(profile.Arch, arch)
+		}
+		if p.NextServer != "" {
+			profile.NextServer = net.ParseIP(p.NextServer).To4()
+			if profile.NextServer == nil {
+				return nil, fmt.Errorf("invalid next server %q", p.NextServer)
+			}
+		}
+		result = append(result, profile)
+	}
+	return result, nil
+}
+
+// toTimers converts renewal and rebinding times to dhcp.Timer. Error is returned unless T1 < T2 < lease time
+func toTimers(renewal *intstr.IntOrString, rebinding *intstr.IntOrString, leaseTime int) (
+	dhcp.Timer, dhcp.Timer, error) {
+	var t1, t2 dhcp.Timer
+	var err error
+	if renewal != nil {
+		t1, err = dhcp.ParseTimer(renewal.String())
+		if err != nil {
+			return t1, t2, err
+		}
+	}
+	if rebinding != nil {
+		t2, err = dhcp.ParseTimer(rebinding.String())
+		if err != nil {
+			return t1, t2, err
+		}
+	}
+	return t1, t2, dhcp.ValidateTimers(t1, t2, leaseTime)
+}
+
+// validateHTTPBootURL returns error if url is not http(s) url or doesn't fit to bootfile field
+func validateHTTPBootURL(bootURL string) error {
+	if bootURL == "" {
+		return nil
+	}
+	u, err := url.Parse(bootURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid http boot url %q", bootURL)
+	}
+	if len(bootURL) > dhcp.MaxBootFileNameLen {
+		return fmt.Errorf("http boot url is longer than %d bytes", dhcp.MaxBootFileNameLen)
+	}
+	return nil
+}
+
 type Option struct {
 	ID    uint8  `json:"id"`
 	Type  string `json:"type"`
-	Value string `json:"value"`
+	Value string `json:"value,omitempty"`
+	// Expression is CEL expression computing option value from the request, e.g. "'pxe-' + mac".
+	// It is used instead of value
+	Expression string `json:"expression,omitempty"`
+	// AlwaysSend forces option to be sent even if client didn't request it in parameter request list
+	AlwaysSend bool `json:"alwaysSend,omitempty"`
+}
+
+type ConflictProbe struct {
+	// Timeout is a number of milliseconds to wait for reply. Default is 500
+	//+kubebuilder:validation:Minimum=1
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// VendorOptions are sent to clients which vendor class identifier (option 60) starts with vendorClass.
+// First matching vendor class is used
+type VendorOptions struct {
+	// VendorClass is vendor class identifier prefix, e.g. "Cisco". Any client matches if empty
+	VendorClass string `json:"vendorClass,omitempty"`
+	// Options are encapsulated sub-options, id is sub-option code
+	Options []Option `json:"options"`
+}
+
+// EnterpriseOptions are sent to clients which sent the enterprise number in option 124 or 125
+type EnterpriseOptions struct {
+	// EnterpriseNumber is IANA private enterprise number, e.g. 9 for Cisco
+	EnterpriseNumber uint32 `json:"enterpriseNumber"`
+	// Options are encapsulated sub-options, id is sub-option code
+	Options []Option `json:"options"`
+}
+
+// toVendorOptions converts vendor and enterprise options. Error is returned if sub-options are invalid
+func toVendorOptions(vendorOptions []VendorOptions, enterpriseOptions []EnterpriseOptions) (
+	[]dhcp.VendorOptions, []dhcp.EnterpriseOptions, error) {
+	var (
+		vendor     []dhcp.VendorOptions
+		enterprise []dhcp.EnterpriseOptions
+	)
+	for _, v := range vendorOptions {
+		opts, err := toOptions(v.Options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("vendor class %q: %w", v.VendorClass, err)
+		}
+		vendor = append(vendor, dhcp.VendorOptions{VendorClass: v.VendorClass, Options: opts})
+	}
+	for _, e := range enterpriseOptions {
+		opts, err := toOptions(e.Options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("enterprise number %d: %w", e.EnterpriseNumber, err)
+		}
+		enterprise = append(enterprise, dhcp.EnterpriseOptions{EnterpriseNumber: e.EnterpriseNumber, Options: opts})
+	}
+	return vendor, enterprise, dhcp.ValidateVendorOptions(vendor, enterprise)
+}
+
+// toOptions converts options to dhcp.Option. Error is returned if value or expression is invalid
+func toOptions(opts []Option) ([]dhcp.Option, error) {
+	result := []dhcp.Option{}
+	for _, opt := range opts {
+		if opt.Value != "" && opt.Expression != "" {
+			return nil, fmt.Errorf("option %d: value and expression are mutually exclusive", opt.ID)
+		}
+		expr, err := dhcp.CompileValueExpression(opt.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("option %d: %w", opt.ID, err)
+		}
+		result = append(result, dhcp.Option{
+			ID:         opt.ID,
+			Type:       opt.Type,
+			Value:      opt.Value,
+			AlwaysSend: opt.AlwaysSend,
+			Expression: expr,
+		})
+	}
+	return result, dhcp.ValidateOptions(result)
 }
 
 type Lease struct {
 	IP        string      `json:"ip"`
 	UpdatedAt metav1.Time `json:"updatedAt"`
+	MAC       string      `json:"mac,omitempty"`
+	ClientID  string      `json:"clientId,omitempty"`
+	DUID      string      `json:"duid,omitempty"`
+	IAID      uint32      `json:"iaid,omitempty"`
+	CircuitID string      `json:"circuitId,omitempty"`
+	RemoteID  string      `json:"remoteId,omitempty"`
+	// Classes are names of client classes matched by the client
+	Classes []string `json:"classes,omitempty"`
+	// LeaseTime is number of seconds the lease is valid since UpdatedAt. Subnet lease time is assumed if zero
+	LeaseTime int `json:"leaseTime,omitempty"`
+	// Static lease is given by host reservation and never expires
+	Static bool `json:"static,omitempty"`
+}
+
+type DelegatedPrefix struct {
+	DUID        string      `json:"duid"`
+	IAID        uint32      `json:"iaid"`
+	PeerAddress string      `json:"peerAddress,omitempty"`
+	UpdatedAt   metav1.Time `json:"updatedAt"`
+}
+
+type DeclinedAddress struct {
+	MAC              string      `json:"mac"`
+	DeclinedAt       metav1.Time `json:"declinedAt"`
+	QuarantinedUntil metav1.Time `json:"quarantinedUntil"`
+	// Probe is a method address was found in use by before offering it to mac, "arp" or "icmp".
+	// Empty if the client declined the address
+	Probe string `json:"probe,omitempty"`
+	// RespondedBy is hardware address which answered ARP probe
+	RespondedBy string `json:"respondedBy,omitempty"`
 }
 
 // DHCPSubnetStatus defines the observed state of DHCPSubnet
 type DHCPSubnetStatus struct {
-	ErrorMessage string           `json:"errorMessage"`
-	Leases       map[string]Lease `json:"leases"`
+	ErrorMessage string `json:"errorMessage"`
+	// Leases by MAC, by "id:<client id>" for leases bound to client identifier,
+	// or by "duid:<duid>/<iaid>" for DHCPv6 bindings
+	Leases map[string]Lease `json:"leases"`
+	// Declined addresses reported by clients as being in use, by ip
+	Declined map[string]DeclinedAddress `json:"declined,omitempty"`
+	// DelegatedPrefixes by prefix, e.g. "2001:db8:100:100::/56". Requesting router is reachable via peer address
+	DelegatedPrefixes map[string]DelegatedPrefix `json:"delegatedPrefixes,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -82,24 +329,59 @@ func init() {
 	SchemeBuilder.Register(&DHCPSubnet{}, &DHCPSubnetList{})
 }
 
-func (s *DHCPSubnet) ToSubnet() dhcp.Subnet {
+// ToSubnet converts object to dhcp.Subnet. Error is returned if options or expressions are invalid
+func (s *DHCPSubnet) ToSubnet() (dhcp.Subnet, error) {
 	sn := dhcp.Subnet{
 		Subnet:         dhcp.SubnetAddrPrefix(s.Spec.Subnet),
 		RangeFrom:      s.Spec.RangeFrom,
 		RangeTo:        s.Spec.RangeTo,
 		Gateway:        s.Spec.Gateway,
 		DNS:            s.Spec.DNS,
-		Options:        []dhcp.Option{},
 		LeaseTime:      s.Spec.LeaseTime,
 		ServerHostName: s.Spec.ServerHostName,
 		BootFileName:   s.Spec.BootFileName,
+		MinLeaseTime:   s.Spec.MinLeaseTime,
+		MaxLeaseTime:   s.Spec.MaxLeaseTime,
+
+		DeclineQuarantineTime: s.Spec.DeclineQuarantineTime,
+		ClientIDPolicy:        s.Spec.ClientIDPolicy,
+		RapidCommit:           s.Spec.RapidCommit,
+		HTTPBootURL:           s.Spec.HTTPBootURL,
+		EmbeddedTFTP:          s.Spec.EmbeddedTFTP,
+		IPXEScript:            s.Spec.IPXEScript,
+		BootParams:            s.Spec.BootParams,
 	}
-	for _, opt := range s.Spec.Options {
-		sn.Options = append(sn.Options, dhcp.Option{
-			ID:    opt.ID,
-			Type:  opt.Type,
-			Value: opt.Value,
-		})
+	if s.Spec.ConflictProbe != nil {
+		sn.ProbeTimeout = defaultProbeTimeout
+		if s.Spec.ConflictProbe.Timeout != 0 {
+			sn.ProbeTimeout = time.Millisecond * time.Duration(s.Spec.ConflictProbe.Timeout)
+		}
+	}
+	if s.Spec.PrefixDelegation != nil {
+		sn.PDPrefix = s.Spec.PrefixDelegation.Prefix
+		sn.PDLength = s.Spec.PrefixDelegation.DelegatedLength
+	}
+	var err error
+	sn.RenewalTime, sn.RebindingTime, err = toTimers(s.Spec.RenewalTime, s.Spec.RebindingTime, s.Spec.LeaseTime)
+	if err != nil {
+		return sn, err
+	}
+	sn.BootProfiles, err = toBootProfiles(s.Spec.BootProfiles)
+	if err != nil {
+		return sn, err
+	}
+	err = validateHTTPBootURL(s.Spec.HTTPBootURL)
+	if err != nil {
+		return sn, err
+	}
+	sn.Match, err = dhcp.CompileMatchExpression(s.Spec.MatchExpression)
+	if err != nil {
+		return sn, err
+	}
+	sn.VendorOptions, sn.EnterpriseOptions, err = toVendorOptions(s.Spec.VendorOptions, s.Spec.EnterpriseOptions)
+	if err != nil {
+		return sn, err
 	}
-	return sn
+	sn.Options, err = toOptions(s.Spec.Options)
+	return sn, err
 }
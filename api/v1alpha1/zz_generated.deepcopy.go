@@ -23,8 +23,203 @@ package v1alpha1
 
 import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BootProfile) DeepCopyInto(out *BootProfile) {
+	*out = *in
+	if in.Arch != nil {
+		in, out := &in.Arch, &out.Arch
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootProfile.
+func (in *BootProfile) DeepCopy() *BootProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(BootProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClientClassMatch) DeepCopyInto(out *ClientClassMatch) {
+	*out = *in
+	if in.VendorClass != nil {
+		in, out := &in.VendorClass, &out.VendorClass
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UserClass != nil {
+		in, out := &in.UserClass, &out.UserClass
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Arch != nil {
+		in, out := &in.Arch, &out.Arch
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MACPrefix != nil {
+		in, out := &in.MACPrefix, &out.MACPrefix
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CircuitID != nil {
+		in, out := &in.CircuitID, &out.CircuitID
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemoteID != nil {
+		in, out := &in.RemoteID, &out.RemoteID
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HostName != nil {
+		in, out := &in.HostName, &out.HostName
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClientClassMatch.
+func (in *ClientClassMatch) DeepCopy() *ClientClassMatch {
+	if in == nil {
+		return nil
+	}
+	out := new(ClientClassMatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConflictProbe) DeepCopyInto(out *ConflictProbe) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConflictProbe.
+func (in *ConflictProbe) DeepCopy() *ConflictProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(ConflictProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DHCPClientClass) DeepCopyInto(out *DHCPClientClass) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPClientClass.
+func (in *DHCPClientClass) DeepCopy() *DHCPClientClass {
+	if in == nil {
+		return nil
+	}
+	out := new(DHCPClientClass)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DHCPClientClass) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DHCPClientClassList) DeepCopyInto(out *DHCPClientClassList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DHCPClientClass, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPClientClassList.
+func (in *DHCPClientClassList) DeepCopy() *DHCPClientClassList {
+	if in == nil {
+		return nil
+	}
+	out := new(DHCPClientClassList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DHCPClientClassList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DHCPClientClassSpec) DeepCopyInto(out *DHCPClientClassSpec) {
+	*out = *in
+	in.Match.DeepCopyInto(&out.Match)
+	if in.Options != nil {
+		in, out := &in.Options, &out.Options
+		*out = make([]Option, len(*in))
+		copy(*out, *in)
+	}
+	if in.VendorOptions != nil {
+		in, out := &in.VendorOptions, &out.VendorOptions
+		*out = make([]VendorOptions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EnterpriseOptions != nil {
+		in, out := &in.EnterpriseOptions, &out.EnterpriseOptions
+		*out = make([]EnterpriseOptions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPClientClassSpec.
+func (in *DHCPClientClassSpec) DeepCopy() *DHCPClientClassSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DHCPClientClassSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DHCPClientClassStatus) DeepCopyInto(out *DHCPClientClassStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPClientClassStatus.
+func (in *DHCPClientClassStatus) DeepCopy() *DHCPClientClassStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DHCPClientClassStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DHCPHost) DeepCopyInto(out *DHCPHost) {
 	*out = *in
@@ -97,6 +292,30 @@ func (in *DHCPHostSpec) DeepCopyInto(out *DHCPHostSpec) {
 		*out = make([]Option, len(*in))
 		copy(*out, *in)
 	}
+	if in.BootProfiles != nil {
+		in, out := &in.BootProfiles, &out.BootProfiles
+		*out = make([]BootProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BootParams != nil {
+		in, out := &in.BootParams, &out.BootParams
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.RenewalTime != nil {
+		in, out := &in.RenewalTime, &out.RenewalTime
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.RebindingTime != nil {
+		in, out := &in.RebindingTime, &out.RebindingTime
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPHostSpec.
@@ -129,7 +348,7 @@ func (in *DHCPServer) DeepCopyInto(out *DHCPServer) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -186,6 +405,16 @@ func (in *DHCPServerList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DHCPServerSpec) DeepCopyInto(out *DHCPServerSpec) {
 	*out = *in
+	if in.Relay != nil {
+		in, out := &in.Relay, &out.Relay
+		*out = new(Relay)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ProxyDHCP != nil {
+		in, out := &in.ProxyDHCP, &out.ProxyDHCP
+		*out = new(ProxyDHCP)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPServerSpec.
@@ -286,6 +515,54 @@ func (in *DHCPSubnetSpec) DeepCopyInto(out *DHCPSubnetSpec) {
 		*out = make([]Option, len(*in))
 		copy(*out, *in)
 	}
+	if in.RenewalTime != nil {
+		in, out := &in.RenewalTime, &out.RenewalTime
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.RebindingTime != nil {
+		in, out := &in.RebindingTime, &out.RebindingTime
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.PrefixDelegation != nil {
+		in, out := &in.PrefixDelegation, &out.PrefixDelegation
+		*out = new(PrefixDelegation)
+		**out = **in
+	}
+	if in.BootProfiles != nil {
+		in, out := &in.BootProfiles, &out.BootProfiles
+		*out = make([]BootProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BootParams != nil {
+		in, out := &in.BootParams, &out.BootParams
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ConflictProbe != nil {
+		in, out := &in.ConflictProbe, &out.ConflictProbe
+		*out = new(ConflictProbe)
+		**out = **in
+	}
+	if in.VendorOptions != nil {
+		in, out := &in.VendorOptions, &out.VendorOptions
+		*out = make([]VendorOptions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EnterpriseOptions != nil {
+		in, out := &in.EnterpriseOptions, &out.EnterpriseOptions
+		*out = make([]EnterpriseOptions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	in.Server.DeepCopyInto(&out.Server)
 }
 
@@ -309,6 +586,20 @@ func (in *DHCPSubnetStatus) DeepCopyInto(out *DHCPSubnetStatus) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.Declined != nil {
+		in, out := &in.Declined, &out.Declined
+		*out = make(map[string]DeclinedAddress, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.DelegatedPrefixes != nil {
+		in, out := &in.DelegatedPrefixes, &out.DelegatedPrefixes
+		*out = make(map[string]DelegatedPrefix, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPSubnetStatus.
@@ -321,10 +612,68 @@ func (in *DHCPSubnetStatus) DeepCopy() *DHCPSubnetStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeclinedAddress) DeepCopyInto(out *DeclinedAddress) {
+	*out = *in
+	in.DeclinedAt.DeepCopyInto(&out.DeclinedAt)
+	in.QuarantinedUntil.DeepCopyInto(&out.QuarantinedUntil)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeclinedAddress.
+func (in *DeclinedAddress) DeepCopy() *DeclinedAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(DeclinedAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DelegatedPrefix) DeepCopyInto(out *DelegatedPrefix) {
+	*out = *in
+	in.UpdatedAt.DeepCopyInto(&out.UpdatedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DelegatedPrefix.
+func (in *DelegatedPrefix) DeepCopy() *DelegatedPrefix {
+	if in == nil {
+		return nil
+	}
+	out := new(DelegatedPrefix)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnterpriseOptions) DeepCopyInto(out *EnterpriseOptions) {
+	*out = *in
+	if in.Options != nil {
+		in, out := &in.Options, &out.Options
+		*out = make([]Option, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnterpriseOptions.
+func (in *EnterpriseOptions) DeepCopy() *EnterpriseOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(EnterpriseOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Lease) DeepCopyInto(out *Lease) {
 	*out = *in
 	in.UpdatedAt.DeepCopyInto(&out.UpdatedAt)
+	if in.Classes != nil {
+		in, out := &in.Classes, &out.Classes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Lease.
@@ -351,3 +700,80 @@ func (in *Option) DeepCopy() *Option {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrefixDelegation) DeepCopyInto(out *PrefixDelegation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrefixDelegation.
+func (in *PrefixDelegation) DeepCopy() *PrefixDelegation {
+	if in == nil {
+		return nil
+	}
+	out := new(PrefixDelegation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProxyDHCP) DeepCopyInto(out *ProxyDHCP) {
+	*out = *in
+	if in.BootProfiles != nil {
+		in, out := &in.BootProfiles, &out.BootProfiles
+		*out = make([]BootProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProxyDHCP.
+func (in *ProxyDHCP) DeepCopy() *ProxyDHCP {
+	if in == nil {
+		return nil
+	}
+	out := new(ProxyDHCP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Relay) DeepCopyInto(out *Relay) {
+	*out = *in
+	if in.Upstreams != nil {
+		in, out := &in.Upstreams, &out.Upstreams
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Relay.
+func (in *Relay) DeepCopy() *Relay {
+	if in == nil {
+		return nil
+	}
+	out := new(Relay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VendorOptions) DeepCopyInto(out *VendorOptions) {
+	*out = *in
+	if in.Options != nil {
+		in, out := &in.Options, &out.Options
+		*out = make([]Option, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VendorOptions.
+func (in *VendorOptions) DeepCopy() *VendorOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(VendorOptions)
+	in.DeepCopyInto(out)
+	return out
+}
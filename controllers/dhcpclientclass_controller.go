@@ -0,0 +1,92 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"github.com/bmcgo/k8s-dhcp/dhcp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dhcpv1alpha1 "github.com/bmcgo/k8s-dhcp/api/v1alpha1"
+)
+
+// DHCPClientClassReconciler reconciles a DHCPClientClass object
+type DHCPClientClassReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	DHCPServer *dhcp.Server
+}
+
+func NewDHCPClientClassReconciler(c client.Client, scheme *runtime.Scheme) *DHCPClientClassReconciler {
+	return &DHCPClientClassReconciler{
+		Client: c,
+		Scheme: scheme,
+	}
+}
+
+//+kubebuilder:rbac:groups=dhcp.kaas.mirantis.com,resources=dhcpclientclasses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dhcp.kaas.mirantis.com,resources=dhcpclientclasses/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dhcp.kaas.mirantis.com,resources=dhcpclientclasses/finalizers,verbs=update
+
+// Reconcile adds client class to the server, or replaces class with the same namespaced name.
+// Invalid class is reported in status and removed from the server.
+func (r *DHCPClientClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	l.Info("reconcile", "class", req)
+	class := dhcpv1alpha1.DHCPClientClass{}
+	err := r.Client.Get(ctx, client.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Name,
+	}, &class)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			l.Info("client class deleted")
+			return ctrl.Result{Requeue: false}, r.DHCPServer.DeleteClientClass(req.NamespacedName.String())
+		}
+		l.Error(err, "Failed to load DHCPClientClass")
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 30}, err
+	}
+
+	c, err := class.ToClientClass()
+	if err == nil {
+		err = r.DHCPServer.AddClientClass(req.NamespacedName.String(), c)
+	}
+	if err != nil {
+		l.Error(err, "Invalid client class")
+		_ = r.DHCPServer.DeleteClientClass(req.NamespacedName.String())
+		class.Status.ErrorMessage = err.Error()
+		return ctrl.Result{}, r.Status().Update(ctx, &class)
+	}
+	if class.Status.ErrorMessage != "" {
+		class.Status.ErrorMessage = ""
+		return ctrl.Result{}, r.Status().Update(ctx, &class)
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DHCPClientClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dhcpv1alpha1.DHCPClientClass{}).
+		Complete(r)
+}
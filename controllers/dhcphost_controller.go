@@ -37,7 +37,7 @@ type DHCPHostReconciler struct {
 	Scheme     *runtime.Scheme
 	DHCPServer *dhcp.Server
 
-	hostsCache   map[string]dhcp.Host
+	hostsCache   map[string]dhcp.Host //last valid host by namespaced name, to remove it on delete or invalid update
 	knownObjects *ObjectsCache
 }
 
@@ -45,6 +45,7 @@ func NewDHCPHostReconciler(c client.Client, scheme *runtime.Scheme, knownObjects
 	return &DHCPHostReconciler{
 		Client:       c,
 		Scheme:       scheme,
+		hostsCache:   map[string]dhcp.Host{},
 		knownObjects: knownObjects,
 	}
 }
@@ -78,6 +79,7 @@ func (r *DHCPHostReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			if !ok {
 				return ctrl.Result{Requeue: false}, fmt.Errorf("unknown host deleted %s", key)
 			}
+			delete(r.hostsCache, key)
 			err = r.DHCPServer.DeleteHost(sn)
 			return ctrl.Result{Requeue: false}, err
 		}
@@ -85,9 +87,38 @@ func (r *DHCPHostReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 30}, err
 	}
 
+	h, err := host.ToDHCPHost()
+	if err != nil {
+		l.Error(err, "Invalid host")
+		if prev, ok := r.hostsCache[key]; ok {
+			//reservation of the previous valid spec must not outlive it
+			delete(r.hostsCache, key)
+			if err := r.DHCPServer.DeleteHost(prev); err != nil {
+				l.Error(err, "failed to delete previous host")
+			}
+		}
+		host.Status.ErrorMessage = err.Error()
+		return ctrl.Result{}, r.Status().Update(ctx, &host)
+	}
+	if host.Status.ErrorMessage != "" {
+		host.Status.ErrorMessage = ""
+		err = r.Status().Update(ctx, &host)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if prev, ok := r.hostsCache[key]; ok && (prev.MAC != h.MAC || prev.ClientID != h.ClientID ||
+		prev.DUID != h.DUID || !prev.IP.Equal(h.IP)) {
+		//reservation moved to another client or address, so the previous one is replaced rather than kept
+		if err := r.DHCPServer.DeleteHost(prev); err != nil {
+			l.Error(err, "failed to delete previous host")
+		}
+	}
+	r.hostsCache[key] = h
 	saved := r.knownObjects.AddHostIfNotKnown(host)
 	if !saved {
-		err = r.DHCPServer.AddHost(host.ToDHCPHost())
+		err = r.DHCPServer.AddHost(h)
 		if err != nil {
 			l.Error(err, "failed to add host")
 		}
@@ -97,7 +97,10 @@ func (r *DHCPServerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 	l.Info("New Listen", "obj", sv)
-	err = r.DHCPServer.AddListen(sv.ToListen())
+	listen, err := sv.ToListen()
+	if err == nil {
+		err = r.DHCPServer.AddListen(listen)
+	}
 	if err != nil {
 		l.Error(err, "Failed to add listen")
 		sv.Status.ErrorMessage = err.Error()
@@ -121,8 +124,13 @@ func (r *DHCPServerReconciler) Initialize(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	for _, sn := range subnetList.Items {
-		err = r.DHCPServer.AddSubnet(sn.ToSubnet())
+	for _, item := range subnetList.Items {
+		sn, err := item.ToSubnet()
+		if err != nil {
+			r.log.Errorf(err, "Skipping invalid subnet %s", item.Name)
+			continue
+		}
+		err = r.DHCPServer.AddSubnet(sn)
 		if err != nil {
 			return err
 		}
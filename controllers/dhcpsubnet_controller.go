@@ -87,25 +87,50 @@ func (r *DHCPSubnetReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 30}, err
 	}
 
+	s, err := subnet.ToSubnet()
+	if err != nil {
+		l.Error(err, "Invalid subnet")
+		return ctrl.Result{}, r.setErrorMessage(ctx, &subnet, err.Error())
+	}
+	if subnet.Status.ErrorMessage != "" {
+		err = r.setErrorMessage(ctx, &subnet, "")
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 	r.SubnetToObjectKey[dhcp.SubnetAddrPrefix(subnet.Spec.Subnet)] = client.ObjectKeyFromObject(&subnet)
-	s := subnet.ToSubnet()
 	if !r.knownObjects.AddSubnetIfNotKnown(s) {
 		l.Info("Subnet already known")
 		return ctrl.Result{}, nil
 	}
 	r.SubnetCache[req.Name] = s.Subnet
 	err = r.DHCPServer.AddSubnet(s)
-	if err == nil {
-		for _, host := range r.knownObjects.PopUnknownHosts(s.Subnet) {
-			err := r.DHCPServer.AddHost(host.ToDHCPHost())
-			if err != nil {
-				l.Error(err, "Error adding previously saved host")
-			} else {
-				l.Info("Added previously saved host %s", host.Name)
-			}
+	if err != nil {
+		l.Error(err, "Failed to add subnet")
+		return ctrl.Result{}, r.setErrorMessage(ctx, &subnet, err.Error())
+	}
+	for _, host := range r.knownObjects.PopUnknownHosts(s.Subnet) {
+		h, err := host.ToDHCPHost()
+		if err != nil {
+			l.Error(err, "Skipping invalid previously saved host")
+			continue
+		}
+		err = r.DHCPServer.AddHost(h)
+		if err != nil {
+			l.Error(err, "Error adding previously saved host")
+		} else {
+			l.Info("Added previously saved host %s", host.Name)
 		}
 	}
-	return ctrl.Result{}, err
+	return ctrl.Result{}, nil
+}
+
+func (r *DHCPSubnetReconciler) setErrorMessage(ctx context.Context, subnet *dhcpv1alpha1.DHCPSubnet, msg string) error {
+	subnet.Status.ErrorMessage = msg
+	if subnet.Status.Leases == nil {
+		subnet.Status.Leases = map[string]dhcpv1alpha1.Lease{}
+	}
+	return r.Status().Update(ctx, subnet)
 }
 
 func (r *DHCPSubnetReconciler) CallbackSaveLeases(responses []dhcp.Response) error {
@@ -138,11 +163,51 @@ func (r *DHCPSubnetReconciler) CallbackSaveLeases(responses []dhcp.Response) err
 			subnet.Status.Leases = map[string]dhcpv1alpha1.Lease{}
 		}
 		for _, lease := range leases {
-			subnet.Status.Leases[lease.MAC] = dhcpv1alpha1.Lease{
+			if lease.PrefixLength != 0 {
+				saveDelegatedPrefix(&subnet, lease)
+				continue
+			}
+			if lease.Declined && lease.Expired {
+				delete(subnet.Status.Declined, lease.IP.String())
+				continue
+			}
+			if lease.Released || lease.Expired {
+				delete(subnet.Status.Leases, lease.Key())
+				continue
+			}
+			if lease.Declined {
+				delete(subnet.Status.Leases, lease.Key())
+				if subnet.Status.Declined == nil {
+					subnet.Status.Declined = map[string]dhcpv1alpha1.DeclinedAddress{}
+				}
+				declined := dhcpv1alpha1.DeclinedAddress{
+					MAC:              lease.MAC,
+					DeclinedAt:       metav1.NewTime(lease.LastUpdate),
+					QuarantinedUntil: metav1.NewTime(lease.QuarantinedUntil),
+					Probe:            string(lease.ConflictProbe),
+				}
+				if lease.ConflictHWAddr != nil {
+					declined.RespondedBy = lease.ConflictHWAddr.String()
+				}
+				subnet.Status.Declined[lease.IP.String()] = declined
+				continue
+			}
+			delete(subnet.Status.Declined, lease.IP.String())
+			subnet.Status.Leases[lease.Key()] = dhcpv1alpha1.Lease{
 				IP:        lease.IP.String(),
 				UpdatedAt: metav1.Now(),
+				MAC:       lease.MAC,
+				ClientID:  lease.ClientID,
+				DUID:      lease.DUID,
+				IAID:      lease.IAID,
+				CircuitID: lease.CircuitID,
+				RemoteID:  lease.RemoteID,
+				Classes:   lease.Classes,
+				LeaseTime: lease.LeaseTime,
+				Static:    lease.Static,
 			}
 		}
+		pruneExpiredLeases(&subnet)
 		err = r.Status().Update(ctx, &subnet)
 		if err != nil {
 			return err
@@ -151,6 +216,50 @@ func (r *DHCPSubnetReconciler) CallbackSaveLeases(responses []dhcp.Response) err
 	return nil
 }
 
+// pruneExpiredLeases removes status entries expired while they were not in server cache (e.g. saved before restart),
+// so reaper doesn't report them. Expiry rule of the server cache is used, so reserved addresses are kept
+func pruneExpiredLeases(subnet *dhcpv1alpha1.DHCPSubnet) {
+	for key, lease := range subnet.Status.Leases {
+		l := dhcp.Lease{
+			LeaseTime:  lease.LeaseTime,
+			LastUpdate: lease.UpdatedAt.Time,
+			Static:     lease.Static,
+		}
+		if l.LeaseTime == 0 {
+			l.LeaseTime = subnet.Spec.LeaseTime
+		}
+		if l.LeaseTime != 0 && l.IsExpired() {
+			delete(subnet.Status.Leases, key)
+		}
+	}
+	now := metav1.Now()
+	for ip, declined := range subnet.Status.Declined {
+		if declined.QuarantinedUntil.Before(&now) {
+			delete(subnet.Status.Declined, ip)
+		}
+	}
+}
+
+func saveDelegatedPrefix(subnet *dhcpv1alpha1.DHCPSubnet, lease dhcp.Lease) {
+	prefix := fmt.Sprintf("%s/%d", lease.IP, lease.PrefixLength)
+	if lease.Released || lease.Expired {
+		delete(subnet.Status.DelegatedPrefixes, prefix)
+		return
+	}
+	if subnet.Status.DelegatedPrefixes == nil {
+		subnet.Status.DelegatedPrefixes = map[string]dhcpv1alpha1.DelegatedPrefix{}
+	}
+	dp := dhcpv1alpha1.DelegatedPrefix{
+		DUID:      lease.DUID,
+		IAID:      lease.IAID,
+		UpdatedAt: metav1.Now(),
+	}
+	if lease.PeerAddress != nil {
+		dp.PeerAddress = lease.PeerAddress.String()
+	}
+	subnet.Status.DelegatedPrefixes[prefix] = dp
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *DHCPSubnetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
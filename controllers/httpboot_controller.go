@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"github.com/bmcgo/k8s-dhcp/httpboot"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// HTTPBootLabel marks ConfigMaps which keys are templates rendered by HTTP boot endpoint
+const HTTPBootLabel = "dhcp.bmcgo.dev/http-boot"
+
+// HTTPBootConfigMapReconciler loads templates of labeled ConfigMaps to HTTP boot endpoint
+type HTTPBootConfigMapReconciler struct {
+	client.Client
+	Scheme         *runtime.Scheme
+	HTTPBootServer *httpboot.Server
+}
+
+func NewHTTPBootConfigMapReconciler(c client.Client, scheme *runtime.Scheme, server *httpboot.Server) *HTTPBootConfigMapReconciler {
+	return &HTTPBootConfigMapReconciler{
+		Client:         c,
+		Scheme:         scheme,
+		HTTPBootServer: server,
+	}
+}
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// Reconcile replaces templates of the ConfigMap, or removes them if ConfigMap is deleted or not labeled anymore.
+// Templates are kept unchanged if any of them is invalid
+func (r *HTTPBootConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	cm := corev1.ConfigMap{}
+	err := r.Client.Get(ctx, req.NamespacedName, &cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			l.Info("HTTP boot templates deleted")
+			r.HTTPBootServer.DeleteTemplates(req.String())
+			return ctrl.Result{Requeue: false}, nil
+		}
+		l.Error(err, "Failed to load ConfigMap")
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 30}, err
+	}
+	if !isHTTPBootConfigMap(&cm) {
+		r.HTTPBootServer.DeleteTemplates(req.String())
+		return ctrl.Result{}, nil
+	}
+	err = r.HTTPBootServer.SetTemplates(req.String(), cm.Data)
+	if err != nil {
+		//invalid template is not retried until ConfigMap is updated
+		l.Error(err, "Invalid HTTP boot templates")
+		return ctrl.Result{}, nil
+	}
+	l.Info("HTTP boot templates updated", "count", len(cm.Data))
+	return ctrl.Result{}, nil
+}
+
+func isHTTPBootConfigMap(o client.Object) bool {
+	return o.GetLabels()[HTTPBootLabel] == "true"
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HTTPBootConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("httpboot-configmap").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool { return isHTTPBootConfigMap(e.Object) },
+			//templates are removed if label is removed
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return isHTTPBootConfigMap(e.ObjectOld) || isHTTPBootConfigMap(e.ObjectNew)
+			},
+			DeleteFunc:  func(e event.DeleteEvent) bool { return isHTTPBootConfigMap(e.Object) },
+			GenericFunc: func(e event.GenericEvent) bool { return isHTTPBootConfigMap(e.Object) },
+		})).
+		Complete(r)
+}
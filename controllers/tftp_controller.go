@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"github.com/bmcgo/k8s-dhcp/tftp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"path"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// TFTPLabel marks ConfigMaps which keys are served as files by embedded TFTP server
+	TFTPLabel = "dhcp.bmcgo.dev/tftp"
+	// TFTPPathAnnotation is a directory files of ConfigMap are served in, e.g. "pxelinux.cfg"
+	TFTPPathAnnotation = "dhcp.bmcgo.dev/tftp-path"
+)
+
+// TFTPConfigMapReconciler serves files of labeled ConfigMaps by embedded TFTP server
+type TFTPConfigMapReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	TFTPServer *tftp.Server
+}
+
+func NewTFTPConfigMapReconciler(c client.Client, scheme *runtime.Scheme, server *tftp.Server) *TFTPConfigMapReconciler {
+	return &TFTPConfigMapReconciler{
+		Client:     c,
+		Scheme:     scheme,
+		TFTPServer: server,
+	}
+}
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// Reconcile replaces files of the ConfigMap, or removes them if ConfigMap is deleted or not labeled anymore
+func (r *TFTPConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	cm := corev1.ConfigMap{}
+	err := r.Client.Get(ctx, req.NamespacedName, &cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			l.Info("TFTP files deleted")
+			r.TFTPServer.Files().Delete(req.String())
+			return ctrl.Result{Requeue: false}, nil
+		}
+		l.Error(err, "Failed to load ConfigMap")
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 30}, err
+	}
+	if !isTFTPConfigMap(&cm) {
+		r.TFTPServer.Files().Delete(req.String())
+		return ctrl.Result{}, nil
+	}
+	dir := cm.Annotations[TFTPPathAnnotation]
+	files := map[string][]byte{}
+	for name, data := range cm.Data {
+		files[path.Join(dir, name)] = []byte(data)
+	}
+	for name, data := range cm.BinaryData {
+		files[path.Join(dir, name)] = data
+	}
+	l.Info("TFTP files updated", "count", len(files), "path", dir)
+	r.TFTPServer.Files().Set(req.String(), files)
+	return ctrl.Result{}, nil
+}
+
+func isTFTPConfigMap(o client.Object) bool {
+	return o.GetLabels()[TFTPLabel] == "true"
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TFTPConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool { return isTFTPConfigMap(e.Object) },
+			//files are removed if label is removed
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return isTFTPConfigMap(e.ObjectOld) || isTFTPConfigMap(e.ObjectNew)
+			},
+			DeleteFunc:  func(e event.DeleteEvent) bool { return isTFTPConfigMap(e.Object) },
+			GenericFunc: func(e event.GenericEvent) bool { return isTFTPConfigMap(e.Object) },
+		})).
+		Complete(r)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
 	"net"
 	"strconv"
 	"sync"
@@ -18,32 +20,117 @@ type SubnetAddrPrefix string
 type Host struct {
 	Subnet         string
 	MAC            string
+	ClientID       string
+	DUID           string
 	IP             net.IP
+	Prefix         *net.IPNet //DHCPv6 delegated prefix reservation
 	Gateway        net.IP
 	ServerHostName string
 	BootFileName   string
+	BootProfiles   []BootProfile
+	HTTPBootURL    string
 	DNS            []string
 	Options        []Option
 	LeaseTime      int
 	HostName       string
+	RenewalTime    Timer
+	RebindingTime  Timer
+	IPXEScript     string
+	BootParams     map[string]string //merged with subnet boot parameters, host values take precedence
+}
+
+// BootProfile defines boot parameters for clients of given architectures (option 93).
+// Profile with IPXE set matches only clients which are iPXE already (user class "iPXE"), and profile
+// without it matches only firmware PXE clients, so iPXE is not chainloaded again.
+type BootProfile struct {
+	Arch           []iana.Arch //any architecture if empty, except of UEFI HTTP Boot clients
+	IPXE           bool
+	BootFileName   string
+	NextServer     net.IP
+	TFTPServerName string
+}
+
+// ClientClass matches clients by request attributes and overrides subnet parameters for them.
+// Matching classes are applied between subnet defaults and host reservations, in priority order.
+type ClientClass struct {
+	Name     string
+	Priority int //class with higher priority overrides parameters of classes with lower priority
+	Match    ClientClassMatch
+
+	Options      []Option
+	BootFileName string
+	LeaseTime    int
+	RangeFrom    string //pool restriction: new addresses are allocated from RangeFrom-RangeTo only
+	RangeTo      string
+
+	VendorOptions     []VendorOptions
+	EnterpriseOptions []EnterpriseOptions
+
+	key    string //key the class was added by
+	iPFrom IPv4
+	iPTo   IPv4
+}
+
+// ClientClassMatch is a set of client conditions. Client matches if every non-empty condition
+// matches any of its values
+type ClientClassMatch struct {
+	VendorClass []string //vendor class identifier (option 60) prefixes, e.g. "PXEClient"
+	UserClass   []string //user class (option 77), e.g. "iPXE"
+	Arch        []iana.Arch
+	MACPrefix   []string //hardware address prefixes, e.g. OUI "52:54:00"
+	CircuitID   []string //relay agent circuit id, printable or hex as in lease
+	RemoteID    []string //relay agent remote id, printable or hex as in lease
+	HostName    []string //host name (option 12) patterns, e.g. "node-*"
+	Expression  *Expression
 }
 
 type Lease struct {
 	Subnet         SubnetAddrPrefix
 	MAC            string
+	ClientID       string //set if lease is bound to client identifier instead of MAC
+	DUID           string //set for DHCPv6 bindings
+	IAID           uint32
+	PrefixLength   int    //set for DHCPv6 delegated prefixes, IP is prefix address
+	PeerAddress    net.IP //address of DHCPv6 client (requesting router)
 	IP             net.IP
 	NetMask        string
 	Gateway        net.IP
 	ServerHostName string
 	BootFileName   string
+	BootProfiles   []BootProfile
+	HTTPBootURL    string //boot file for UEFI HTTP Boot clients
 	DNS            []string
 	Options        []Option
 	LeaseTime      int
 	HostName       string
 	ServerId       net.IP
+	RenewalTime    Timer             //T1 of host reservation
+	RebindingTime  Timer             //T2 of host reservation
+	IPXEScript     string            //template rendered by HTTP boot endpoint for iPXE clients
+	BootParams     map[string]string //parameters of boot templates
 
 	LastUpdate time.Time
 	AckSent    bool
+	Released   bool
+	Static     bool
+
+	Declined         bool
+	QuarantinedUntil time.Time
+	Expired          bool
+	ConflictProbe    ProbeMethod      //set if declined address was found in use by probe instead of client
+	ConflictHWAddr   net.HardwareAddr //hardware address which answered ARP probe
+	probePending     bool
+
+	CircuitID string
+	RemoteID  string
+
+	Classes []string //names of client classes matched by the client
+}
+
+// LeaseBinding is client data recorded on the lease by subnet allocator
+type LeaseBinding struct {
+	LeaseTime int             //granted lease time of dynamic lease, subnet lease time is kept if zero
+	RelayInfo *RelayAgentInfo //relay agent ids are kept if nil
 }
 
 type Subnet struct {
@@ -56,6 +143,36 @@ type Subnet struct {
 	LeaseTime      int
 	ServerHostName string
 	BootFileName   string
+	BootProfiles   []BootProfile
+	HTTPBootURL    string
+	//EmbeddedTFTP sets next server (siaddr) to the address of this server, so boot files are loaded from its
+	//TFTP server. Subnet is rejected if the server is not configured with EmbeddedTFTP
+	EmbeddedTFTP bool
+	//IPXEScript is a name of template iPXE clients get rendered by HTTP boot endpoint of this server
+	IPXEScript string
+	BootParams map[string]string
+
+	VendorOptions     []VendorOptions     //option 43 sub-options by vendor class
+	EnterpriseOptions []EnterpriseOptions //option 125 sub-options by enterprise number
+
+	//RenewalTime and RebindingTime are T1 and T2 sent to clients, unless host reservation has its own
+	RenewalTime   Timer
+	RebindingTime Timer
+	//MinLeaseTime and MaxLeaseTime limit lease time requested by client. Requested time is ignored if both are zero
+	MinLeaseTime int
+	MaxLeaseTime int
+
+	DeclineQuarantineTime int
+	ClientIDPolicy        string
+	RapidCommit           bool
+	//ProbeTimeout enables probing new addresses for conflicts before offering them
+	ProbeTimeout time.Duration
+	//Match ignores DHCPv4 requests for which expression is false
+	Match *Expression
+
+	//DHCPv6 prefix delegation pool: prefixes of PDLength are delegated from PDPrefix
+	PDPrefix string
+	PDLength int
 
 	iPFrom     IPv4
 	iPTo       IPv4
@@ -66,11 +183,43 @@ type Subnet struct {
 
 	serverIPAddress net.IP
 	leaseCacheMutex *sync.Mutex
+	stopReaper      context.CancelFunc
+}
+
+// Subnet6 is DHCPv6 subnet, made of DHCPSubnet with IPv6 prefix
+type Subnet6 struct {
+	Subnet      SubnetAddrPrefix
+	RangeFrom   string
+	RangeTo     string
+	DNS         []string
+	LeaseTime   int
+	RapidCommit bool
+	PDPrefix    string
+	PDLength    int
+
+	pdNet      *net.IPNet
+	pdCurrent  uint64
+	pdSize     uint64
+	ipNet      *net.IPNet
+	ipFrom     net.IP
+	ipTo       net.IP
+	currentIP  net.IP
+	leaseCache map[string]*Lease
+
+	leaseCacheMutex *sync.Mutex
+	stopReaper      context.CancelFunc
+}
+
+type listener interface {
+	Serve() error
+	Close()
 }
 
 type Server struct {
-	listeners map[string]*RequestProcessor
+	listeners map[string]listener
 	subnets   map[SubnetAddrPrefix]*Subnet
+	subnets6  map[SubnetAddrPrefix]*Subnet6
+	classes   map[string]*ClientClass
 
 	localIpAddresses map[interfaceName][]net.IP
 	serverIds        map[string]bool
@@ -79,22 +228,73 @@ type Server struct {
 	listenMutex *sync.Mutex
 
 	callbackSaveLeases CallbackSaveLeases
+	prober             Prober
 	socketFactory      SocketFactory
+	socket6Factory     Socket6Factory
+	duid               dhcpv6.Duid
+	ipxeScriptPort     int
+	embeddedTFTP       bool
 
 	context context.Context
 	log     RLogger
 }
 
+// Client id policies define how leases are bound to clients
+const (
+	//ClientIDPolicyMAC binds leases to client hardware address
+	ClientIDPolicyMAC = "mac"
+	//ClientIDPolicyClientID binds leases to client identifier (option 61) if client sent one
+	ClientIDPolicyClientID = "client-id"
+	//ClientIDPolicyClientIDWithMACFallback is like ClientIDPolicyClientID, but lease bound to MAC
+	//is taken over by client id if nothing is bound to client id yet (e.g. PXE stage without option 61)
+	ClientIDPolicyClientIDWithMACFallback = "client-id-with-mac-fallback"
+)
+
+type NakReason string
+
+const (
+	NakReasonAddressMismatch     NakReason = "address mismatch"
+	NakReasonAddressNotAvailable NakReason = "address not available"
+	NakReasonWrongSubnet         NakReason = "wrong subnet"
+	NakReasonPoolExhausted       NakReason = "pool exhausted"
+)
+
+// NakError is returned by lease allocator when client should receive DHCPNAK
+type NakError struct {
+	Reason  NakReason
+	Message string
+}
+
+func newNakError(reason NakReason, format string, args ...interface{}) *NakError {
+	return &NakError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *NakError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+const (
+	ProtocolDHCPv4 = "dhcpv4"
+	ProtocolDHCPv6 = "dhcpv6"
+)
+
 type Listen struct {
 	Name      string
 	Interface string
 	Addr      string
+	Protocol  string
+	Relay     *RelayConfig     //listener relays DHCPv4 requests to upstream servers instead of serving them
+	ProxyDHCP *ProxyDHCPConfig //listener serves only boot parameters of PXE clients, without leases
 }
 
 type Option struct {
 	ID    uint8
 	Type  string
 	Value string
+	// AlwaysSend forces option to be sent even if client didn't request it
+	AlwaysSend bool
+	// Expression computes option value for the request instead of Value
+	Expression *Expression
 }
 
 func (l *Listen) ToString() string {
@@ -113,6 +313,20 @@ type Response struct {
 	Request  Request
 	Response dhcpv4.DHCPv4
 	Lease    *Lease
+
+	needsProbe bool //lease address must be probed for conflicts before response is sent
+}
+
+// HasReply returns false if nothing should be sent back to the client (e.g. DHCPRELEASE)
+func (s *Response) HasReply() bool {
+	return s.Response.MessageType() != dhcpv4.MessageTypeNone
+}
+
+// RelayAgentInfo is parsed relay agent information option (82)
+type RelayAgentInfo struct {
+	CircuitID     []byte
+	RemoteID      []byte
+	LinkSelection net.IP
 }
 
 type Request struct {
@@ -121,7 +335,25 @@ type Request struct {
 	InterfaceName interfaceName
 	Dst           net.IP
 
-	socket Socket
+	RelayInfo       *RelayAgentInfo
+	SubnetSelection net.IP
+
+	socket        Socket
+	probeAttempts int //number of offered addresses found in use by probes
+}
+
+type Request6 struct {
+	dhcpv6.DHCPv6
+	Src           net.Addr
+	InterfaceName interfaceName
+
+	socket Socket6
+}
+
+type Response6 struct {
+	Request  Request6
+	Response dhcpv6.DHCPv6 //nil if nothing should be sent back
+	Leases   []*Lease
 }
 
 func (s *Request) ToString() string {
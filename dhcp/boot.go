@@ -0,0 +1,196 @@
+package dhcp
+
+import (
+	"fmt"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// IPXEScriptPath is URL path iPXE scripts are served at by HTTP boot endpoint, followed by script name
+const IPXEScriptPath = "/ipxe/"
+
+// MaxBootFileNameLen is a size of bootfile field of dhcp header, excluding terminating zero
+const MaxBootFileNameLen = 127
+
+// httpClientClass is vendor class identifier (option 60) of UEFI HTTP Boot clients
+const httpClientClass = "HTTPClient"
+
+// archNames are names of client system architectures (RFC 4578, IANA processor architecture types)
+var archNames = map[string]iana.Arch{
+	"bios":           iana.INTEL_X86PC,
+	"efi-ia32":       iana.EFI_IA32,
+	"efi-x64":        iana.EFI_X86_64,
+	"efi-bc":         iana.EFI_BC,
+	"efi-arm32":      iana.EFI_ARM32,
+	"efi-arm64":      iana.EFI_ARM64,
+	"efi-ia32-http":  iana.EFI_X86_HTTP,
+	"efi-x64-http":   iana.EFI_X86_64_HTTP,
+	"efi-arm32-http": iana.EFI_ARM32_HTTP,
+	"efi-arm64-http": iana.EFI_ARM64_HTTP,
+}
+
+// ParseArch parses client architecture name, e.g. "efi-x64", or its number, e.g. "7"
+func ParseArch(name string) (iana.Arch, error) {
+	if arch, ok := archNames[name]; ok {
+		return arch, nil
+	}
+	n, err := strconv.ParseUint(name, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown architecture %q", name)
+	}
+	return iana.Arch(n), nil
+}
+
+// ArchName returns name of client architecture, e.g. "efi-x64", or its number if it has no name
+func ArchName(arch iana.Arch) string {
+	for name, a := range archNames {
+		if a == arch {
+			return name
+		}
+	}
+	return strconv.Itoa(int(arch))
+}
+
+// isIPXE returns true if client is iPXE, i.e. firmware PXE has chainloaded it already
+func isIPXE(req *dhcpv4.DHCPv4) bool {
+	for _, class := range req.UserClass() {
+		if class == "iPXE" {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTTPBootClient returns true if client is UEFI HTTP Boot client, e.g. "HTTPClient:Arch:00016:UNDI:003001"
+func isHTTPBootClient(req *dhcpv4.DHCPv4) bool {
+	return strings.HasPrefix(req.ClassIdentifier(), httpClientClass)
+}
+
+// archMatches returns true if any of client architectures is in the list, or the list is empty
+func archMatches(list []iana.Arch, arch []iana.Arch) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, a := range list {
+		for _, clientArch := range arch {
+			if a == clientArch {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p BootProfile) matches(arch []iana.Arch, ipxe bool) bool {
+	return p.IPXE == ipxe && archMatches(p.Arch, arch)
+}
+
+// selectBootProfile returns first profile matching client's architecture and iPXE user class, or nil.
+// UEFI HTTP Boot clients match only profiles listing their architecture, as others have TFTP boot files
+func selectBootProfile(req *dhcpv4.DHCPv4, profiles []BootProfile) *BootProfile {
+	arch := req.ClientArch()
+	ipxe := isIPXE(req)
+	httpBoot := isHTTPBootClient(req)
+	for i := range profiles {
+		if httpBoot && len(profiles[i].Arch) == 0 {
+			continue
+		}
+		if profiles[i].matches(arch, ipxe) {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// localAddress returns the address clients of the subnet reach this server at: the address unicast (e.g. relayed)
+// request is received at, or the address of the server in the subnet
+func (s *Subnet) localAddress(req Request) net.IP {
+	if !isAddressZero(req.Dst) && !req.Dst.Equal(net.IPv4bcast) && req.Dst.To4() != nil {
+		return req.Dst.To4()
+	}
+	return s.serverIPAddress
+}
+
+// nextServer returns siaddr of replies to clients of the subnet. With embedded TFTP server it is the address
+// request is received at, so relayed clients reach this server too
+func (s *Subnet) nextServer(req Request) net.IP {
+	if s.EmbeddedTFTP {
+		return s.localAddress(req)
+	}
+	return s.serverIPAddress
+}
+
+// setBootParameters sets boot file, next server (siaddr) and tftp server name (option 66) from
+// boot profile matching the client. UEFI HTTP Boot clients get HTTP boot URL if no profile for HTTP boot
+// matches, and vendor class is echoed to them only then. Boot parameters of the lease are kept otherwise
+func (s *Server) setBootParameters(req *dhcpv4.DHCPv4, resp *dhcpv4.DHCPv4, lease *Lease) {
+	httpBoot := isHTTPBootClient(req)
+	profile := selectBootProfile(req, lease.BootProfiles)
+	httpBootFile := false
+	switch {
+	case profile != nil:
+		s.log.Debugf("Boot profile %q for %s (arch %v, ipxe %t)", profile.BootFileName, req.ClientHWAddr,
+			req.ClientArch(), isIPXE(req))
+		resp.BootFileName = profile.BootFileName
+		if profile.TFTPServerName != "" {
+			resp.UpdateOption(dhcpv4.OptTFTPServerName(profile.TFTPServerName))
+			if ip := net.ParseIP(profile.TFTPServerName); ip != nil && ip.To4() != nil {
+				resp.ServerIPAddr = ip.To4()
+			}
+		}
+		if profile.NextServer != nil {
+			resp.ServerIPAddr = profile.NextServer
+		}
+		httpBootFile = httpBoot
+	case httpBoot && lease.HTTPBootURL != "":
+		s.log.Debugf("HTTP boot %s for %s", lease.HTTPBootURL, req.ClientHWAddr)
+		resp.BootFileName = lease.HTTPBootURL
+		httpBootFile = true
+	}
+	if httpBootFile {
+		//HTTP Boot client ignores offers without HTTPClient vendor class
+		resp.UpdateOption(dhcpv4.OptClassIdentifier(httpClientClass))
+	}
+}
+
+// IPXEScriptURL returns URL of the script rendered by HTTP boot endpoint for the client,
+// e.g. "http://10.0.0.1:8080/ipxe/boot.ipxe?arch=efi-x64&mac=52:54:00:12:34:56"
+func IPXEScriptURL(addr net.IP, port int, script string, mac net.HardwareAddr, arch []iana.Arch) string {
+	query := url.Values{"mac": {mac.String()}}
+	if len(arch) > 0 {
+		query.Set("arch", ArchName(arch[0]))
+	}
+	u := url.URL{
+		Scheme:   "http",
+		Host:     net.JoinHostPort(addr.String(), strconv.Itoa(port)),
+		Path:     IPXEScriptPath + url.PathEscape(script),
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// setIPXEScript points iPXE clients to their script rendered by HTTP boot endpoint of this server. Script takes
+// precedence over boot file and boot profiles, firmware PXE clients still get them to chainload iPXE
+func (s *Server) setIPXEScript(req Request, resp *dhcpv4.DHCPv4, lease *Lease, subnet *Subnet) {
+	if lease.IPXEScript == "" || s.ipxeScriptPort == 0 || !isIPXE(req.DHCPv4) {
+		return
+	}
+	addr := subnet.localAddress(req)
+	if addr == nil {
+		s.log.Infof("Skipping iPXE script %q for %s: no server address in subnet %s", lease.IPXEScript,
+			req.ClientHWAddr, subnet.Subnet)
+		return
+	}
+	scriptURL := IPXEScriptURL(addr, s.ipxeScriptPort, lease.IPXEScript, req.ClientHWAddr, req.ClientArch())
+	if len(scriptURL) > MaxBootFileNameLen {
+		s.log.Infof("Skipping iPXE script %q for %s: URL is longer than %d bytes", lease.IPXEScript,
+			req.ClientHWAddr, MaxBootFileNameLen)
+		return
+	}
+	s.log.Debugf("iPXE script %s for %s", scriptURL, req.ClientHWAddr)
+	resp.BootFileName = scriptURL
+}
@@ -82,8 +82,16 @@ func (r *BroadcastResponder) Send(req Request, resp dhcpv4.DHCPv4) error {
 	r.eth.DstMAC = resp.ClientHWAddr
 	r.ip.SrcIP = resp.ServerIPAddr
 	r.ip.DstIP = resp.YourIPAddr
+	if isAddressZero(resp.ServerIPAddr) {
+		r.ip.SrcIP = resp.ServerIdentifier()
+	}
+	if resp.IsBroadcast() || isAddressZero(resp.YourIPAddr) {
+		//client can't receive unicast before it is configured, or reply has no yiaddr (NAK)
+		r.eth.DstMAC = layers.EthernetBroadcast
+		r.ip.DstIP = net.IPv4bcast
+	}
 
-	packet := gopacket.NewPacket(resp.ToBytes(), layers.LayerTypeDHCPv4, gopacket.NoCopy)
+	packet := gopacket.NewPacket(encodeReply(req.DHCPv4, &resp), layers.LayerTypeDHCPv4, gopacket.NoCopy)
 	dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4)
 	dhcp, ok := dhcpLayer.(gopacket.SerializableLayer)
 	if !ok {
@@ -0,0 +1,249 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+)
+
+func InitializeClientClass(class *ClientClass) error {
+	var err error
+	if class.Name == "" {
+		return errors.New("client class without name")
+	}
+	if (class.RangeFrom == "") != (class.RangeTo == "") {
+		return errors.New("both rangeFrom and rangeTo are required for pool restriction")
+	}
+	if class.RangeFrom != "" {
+		class.iPFrom, err = ParseIPv4(class.RangeFrom)
+		if err != nil {
+			return err
+		}
+		class.iPTo, err = ParseIPv4(class.RangeTo)
+		if err != nil {
+			return err
+		}
+		if class.iPFrom > class.iPTo {
+			return errors.New("from > to")
+		}
+	}
+	for _, pattern := range class.Match.HostName {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid host name pattern %q: %w", pattern, err)
+		}
+	}
+	for i, prefix := range class.Match.MACPrefix {
+		class.Match.MACPrefix[i] = strings.ToLower(prefix)
+	}
+	if err = ValidateOptions(class.Options); err != nil {
+		return err
+	}
+	return ValidateVendorOptions(class.VendorOptions, class.EnterpriseOptions)
+}
+
+func (c *ClientClass) hasPool() bool {
+	return c.iPFrom != 0
+}
+
+func matchAny(values []string, match func(string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientClass) matches(req Request) (bool, error) {
+	if !c.matchesStatic(req) {
+		return false, nil
+	}
+	return c.Match.Expression.Match(req)
+}
+
+func (c *ClientClass) matchesStatic(req Request) bool {
+	m := c.Match
+	vendorClass := req.ClassIdentifier()
+	if !matchAny(m.VendorClass, func(v string) bool { return strings.HasPrefix(vendorClass, v) }) {
+		return false
+	}
+	userClass := req.UserClass()
+	if !matchAny(m.UserClass, func(v string) bool {
+		for _, uc := range userClass {
+			if uc == v {
+				return true
+			}
+		}
+		return false
+	}) {
+		return false
+	}
+	if !archMatches(m.Arch, req.ClientArch()) {
+		return false
+	}
+	mac := req.ClientHWAddr.String()
+	if !matchAny(m.MACPrefix, func(v string) bool { return strings.HasPrefix(mac, v) }) {
+		return false
+	}
+	var circuitID, remoteID string
+	if req.RelayInfo != nil {
+		circuitID = formatRelayID(req.RelayInfo.CircuitID)
+		remoteID = formatRelayID(req.RelayInfo.RemoteID)
+	}
+	if !matchAny(m.CircuitID, func(v string) bool { return v == circuitID }) ||
+		!matchAny(m.RemoteID, func(v string) bool { return v == remoteID }) {
+		return false
+	}
+	hostName := req.HostName()
+	return matchAny(m.HostName, func(v string) bool {
+		ok, _ := path.Match(v, hostName)
+		return hostName != "" && ok
+	})
+}
+
+// AddClientClass adds client class or replaces class with the same key, e.g. "namespace/name".
+// Classes with the same name may be added with different keys
+func (s *Server) AddClientClass(key string, class ClientClass) error {
+	err := InitializeClientClass(&class)
+	if err != nil {
+		return err
+	}
+	class.key = key
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	s.classes[key] = &class
+	s.log.Infof("Added client class %s", key)
+	return nil
+}
+
+func (s *Server) DeleteClientClass(key string) error {
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	if _, ok := s.classes[key]; !ok {
+		return fmt.Errorf("client class not found: %s", key)
+	}
+	delete(s.classes, key)
+	s.log.Infof("Deleted client class %s", key)
+	return nil
+}
+
+// matchClientClasses returns classes matched by the request, by priority from highest to lowest
+func (s *Server) matchClientClasses(req Request) []*ClientClass {
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	var matched []*ClientClass
+	for _, class := range s.classes {
+		ok, err := class.matches(req)
+		if err != nil {
+			s.log.Errorf(err, "failed to match client class %s", class.key)
+		}
+		if ok {
+			matched = append(matched, class)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Priority != matched[j].Priority {
+			return matched[i].Priority > matched[j].Priority
+		}
+		if matched[i].Name != matched[j].Name {
+			return matched[i].Name < matched[j].Name
+		}
+		return matched[i].key < matched[j].key
+	})
+	return matched
+}
+
+func classNames(classes []*ClientClass) []string {
+	var names []string
+	for _, class := range classes {
+		names = append(names, class.Name)
+	}
+	return names
+}
+
+// classLeaseTime returns lease time of dynamic lease: one of the highest priority class setting it, or subnet one
+func (s *Subnet) classLeaseTime(classes []*ClientClass) int {
+	for _, class := range classes {
+		if class.LeaseTime != 0 {
+			return class.LeaseTime
+		}
+	}
+	return s.LeaseTime
+}
+
+// applyClientClasses returns copy of the lease with parameters of matched classes applied.
+// Classes override subnet options, boot file and lease time, host reservation overrides classes.
+func applyClientClasses(lease Lease, subnet *Subnet, classes []*ClientClass) Lease {
+	if !lease.Static {
+		//lease time of dynamic lease may be set by classes matched before
+		lease.LeaseTime = subnet.LeaseTime
+	}
+	if len(classes) == 0 {
+		return lease
+	}
+	options := map[uint8]Option{}
+	if !lease.Static {
+		for _, opt := range lease.Options {
+			options[opt.ID] = opt
+		}
+	}
+	hostBootFileName := lease.Static && lease.BootFileName != ""
+	hostLeaseTime := lease.Static && lease.LeaseTime != 0
+	//lowest priority first, so higher priorities override
+	for i := len(classes) - 1; i >= 0; i-- {
+		class := classes[i]
+		for _, opt := range class.Options {
+			options[opt.ID] = opt
+		}
+		if class.BootFileName != "" && !hostBootFileName {
+			lease.BootFileName = class.BootFileName
+			lease.BootProfiles = nil
+		}
+		if class.LeaseTime != 0 && !hostLeaseTime {
+			lease.LeaseTime = class.LeaseTime
+		}
+	}
+	if lease.Static {
+		for _, opt := range lease.Options {
+			options[opt.ID] = opt
+		}
+	}
+	lease.Options = make([]Option, 0, len(options))
+	for _, opt := range options {
+		lease.Options = append(lease.Options, opt)
+	}
+	sort.Slice(lease.Options, func(i, j int) bool {
+		return lease.Options[i].ID < lease.Options[j].ID
+	})
+	return lease
+}
+
+// classPools returns pool restrictions of classes which are inside of the subnet range
+func (s *Subnet) classPools(classes []*ClientClass) []*ClientClass {
+	var pools []*ClientClass
+	for _, class := range classes {
+		if class.hasPool() && class.iPFrom >= s.iPFrom && class.iPTo <= s.iPTo {
+			pools = append(pools, class)
+		}
+	}
+	return pools
+}
+
+func inPools(ip net.IP, pools []*ClientClass) bool {
+	i, err := ParseIPv4(ip.String())
+	if err != nil {
+		return false
+	}
+	for _, p := range pools {
+		if i >= p.iPFrom && i <= p.iPTo {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package dhcp
+
+import (
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"net"
+)
+
+// Expression is compiled CEL (Common Expression Language) expression evaluated against DHCPv4 request.
+// Request fields are available as variables declared in celEnv, e.g. mac, giaddr or options
+type Expression struct {
+	Source  string
+	program cel.Program
+}
+
+var celEnv *cel.Env
+
+func init() {
+	var err error
+	celEnv, err = cel.NewEnv(
+		cel.Variable("mac", cel.StringType),
+		cel.Variable("giaddr", cel.StringType),
+		cel.Variable("ciaddr", cel.StringType),
+		cel.Variable("interface", cel.StringType),
+		cel.Variable("messageType", cel.StringType),
+		cel.Variable("hostname", cel.StringType),
+		cel.Variable("vendorClass", cel.StringType),
+		cel.Variable("circuitId", cel.StringType),
+		cel.Variable("remoteId", cel.StringType),
+		cel.Variable("userClass", cel.ListType(cel.StringType)),
+		cel.Variable("arch", cel.ListType(cel.IntType)),
+		cel.Variable("options", cel.MapType(cel.IntType, cel.BytesType)),
+		ext.Strings(),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func compileExpression(source string, resultType *cel.Type) (*Expression, error) {
+	if source == "" {
+		return nil, nil
+	}
+	ast, issues := celEnv.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, issues.Err())
+	}
+	if ast.OutputType() != resultType {
+		return nil, fmt.Errorf("expression %q returns %s, %s expected", source, ast.OutputType(), resultType)
+	}
+	program, err := celEnv.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	return &Expression{Source: source, program: program}, nil
+}
+
+// CompileMatchExpression compiles expression returning bool. Nil is returned for empty source
+func CompileMatchExpression(source string) (*Expression, error) {
+	return compileExpression(source, cel.BoolType)
+}
+
+// CompileValueExpression compiles expression returning string, e.g. option value. Nil is returned for empty source
+func CompileValueExpression(source string) (*Expression, error) {
+	return compileExpression(source, cel.StringType)
+}
+
+func addrString(ip net.IP) string {
+	if ip == nil {
+		return net.IPv4zero.String()
+	}
+	return ip.String()
+}
+
+// requestVariables returns values of expression variables for the request
+func requestVariables(req Request) map[string]interface{} {
+	var circuitID, remoteID string
+	if req.RelayInfo != nil {
+		circuitID = formatRelayID(req.RelayInfo.CircuitID)
+		remoteID = formatRelayID(req.RelayInfo.RemoteID)
+	}
+	arch := []int64{}
+	for _, a := range req.ClientArch() {
+		arch = append(arch, int64(a))
+	}
+	userClass := req.UserClass()
+	if userClass == nil {
+		userClass = []string{}
+	}
+	options := map[int64][]byte{}
+	for code, data := range req.Options {
+		options[int64(code)] = data
+	}
+	return map[string]interface{}{
+		"mac":         req.ClientHWAddr.String(),
+		"giaddr":      addrString(req.GatewayIPAddr),
+		"ciaddr":      addrString(req.ClientIPAddr),
+		"interface":   string(req.InterfaceName),
+		"messageType": req.MessageType().String(),
+		"hostname":    req.HostName(),
+		"vendorClass": req.ClassIdentifier(),
+		"circuitId":   circuitID,
+		"remoteId":    remoteID,
+		"userClass":   userClass,
+		"arch":        arch,
+		"options":     options,
+	}
+}
+
+func (e *Expression) eval(req Request) (interface{}, error) {
+	out, _, err := e.program.Eval(requestVariables(req))
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", e.Source, err)
+	}
+	return out.Value(), nil
+}
+
+// Match evaluates match expression. Nil expression matches any request
+func (e *Expression) Match(req Request) (bool, error) {
+	if e == nil {
+		return true, nil
+	}
+	out, err := e.eval(req)
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := out.(bool)
+	if !isBool {
+		return false, fmt.Errorf("expression %q returned %T", e.Source, out)
+	}
+	return ok, nil
+}
+
+// Value evaluates value expression
+func (e *Expression) Value(req Request) (string, error) {
+	out, err := e.eval(req)
+	if err != nil {
+		return "", err
+	}
+	value, ok := out.(string)
+	if !ok {
+		return "", fmt.Errorf("expression %q returned %T", e.Source, out)
+	}
+	return value, nil
+}
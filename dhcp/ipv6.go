@@ -0,0 +1,38 @@
+package dhcp
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+func isIPv6Prefix(prefix SubnetAddrPrefix) bool {
+	return strings.Contains(string(prefix), ":")
+}
+
+func parseIPv6(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("invalid ipv6: %s", s)
+	}
+	return ip.To16(), nil
+}
+
+func compareIPv6(a net.IP, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// addIPv6 returns ip + n
+func addIPv6(ip net.IP, n *big.Int) net.IP {
+	i := new(big.Int).SetBytes(ip.To16())
+	i.Add(i, n)
+	rv := make(net.IP, net.IPv6len)
+	i.FillBytes(rv)
+	return rv
+}
+
+func nextIPv6(ip net.IP) net.IP {
+	return addIPv6(ip, big.NewInt(1))
+}
@@ -0,0 +1,169 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Timer is renewal (T1, option 58) or rebinding (T2, option 59) time: absolute number of seconds,
+// or percentage of lease time if Percent is set. Client defaults (50% and 87.5%) are used if timer is zero
+type Timer struct {
+	Seconds int
+	Percent float64
+}
+
+func (t Timer) IsZero() bool {
+	return t.Seconds == 0 && t.Percent == 0
+}
+
+// seconds returns timer value for given lease time
+func (t Timer) seconds(leaseTime int) int {
+	if t.Percent != 0 {
+		return int(float64(leaseTime) * t.Percent / 100)
+	}
+	return t.Seconds
+}
+
+func (t Timer) String() string {
+	if t.Percent != 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(t.Seconds)
+}
+
+// ParseTimer parses number of seconds, e.g. "1800", or percentage of lease time, e.g. "50%" or "87.5%"
+func ParseTimer(s string) (Timer, error) {
+	var t Timer
+	if s == "" {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return t, fmt.Errorf("invalid timer %q: percentage must be between 0 and 100", s)
+		}
+		t.Percent = percent
+		return t, nil
+	}
+	seconds, err := strconv.Atoi(s)
+	if err != nil || seconds < 0 {
+		return t, fmt.Errorf("invalid timer %q", s)
+	}
+	t.Seconds = seconds
+	return t, nil
+}
+
+// ValidateTimers returns error unless T1 < T2 < lease time. Timers are compared with each other only
+// if lease time is unknown (zero) and both timers are of the same kind
+func ValidateTimers(renewal Timer, rebinding Timer, leaseTime int) error {
+	if leaseTime == 0 {
+		if renewal.IsZero() || rebinding.IsZero() || (renewal.Percent == 0) != (rebinding.Percent == 0) {
+			return nil
+		}
+		if renewal.Percent >= rebinding.Percent && renewal.Seconds >= rebinding.Seconds {
+			return fmt.Errorf("renewal time %s must be less than rebinding time %s", renewal, rebinding)
+		}
+		return nil
+	}
+	t1 := renewal.seconds(leaseTime)
+	t2 := rebinding.seconds(leaseTime)
+	if t1 >= leaseTime || t2 >= leaseTime {
+		return fmt.Errorf("renewal time %s and rebinding time %s must be less than lease time %d",
+			renewal, rebinding, leaseTime)
+	}
+	if t1 != 0 && t2 != 0 && t1 >= t2 {
+		return fmt.Errorf("renewal time %s must be less than rebinding time %s", renewal, rebinding)
+	}
+	return nil
+}
+
+// validateLeaseTimes checks lease time limits and timers of the subnet
+func (s *Subnet) validateLeaseTimes() error {
+	if s.MinLeaseTime < 0 || s.MaxLeaseTime < 0 {
+		return errors.New("negative lease time limit")
+	}
+	min, max := s.leaseTimeLimits()
+	if min > max {
+		return fmt.Errorf("min lease time %d is greater than max lease time %d", min, max)
+	}
+	//absolute and relative timers must be ordered for any lease time client may get
+	for _, leaseTime := range []int{min, s.LeaseTime, max} {
+		if err := ValidateTimers(s.RenewalTime, s.RebindingTime, leaseTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leaseTimeLimits returns range of lease time clients may request. Unset limit defaults to configured lease time
+func (s *Subnet) leaseTimeLimits() (int, int) {
+	min, max := s.MinLeaseTime, s.MaxLeaseTime
+	if min == 0 {
+		min = s.LeaseTime
+	}
+	if max == 0 {
+		max = s.LeaseTime
+	}
+	return min, max
+}
+
+// grantedLeaseTime returns lease time requested by client (option 51) clamped to MinLeaseTime-MaxLeaseTime.
+// Configured lease time is returned if client didn't request one, or subnet has no lease time limits
+func (s *Subnet) grantedLeaseTime(req *dhcpv4.DHCPv4, leaseTime int) int {
+	if s.MinLeaseTime == 0 && s.MaxLeaseTime == 0 {
+		return leaseTime
+	}
+	requested := int64(req.IPAddressLeaseTime(0) / time.Second)
+	if requested == 0 {
+		return leaseTime
+	}
+	min, max := s.leaseTimeLimits()
+	switch {
+	case requested < int64(min):
+		return min
+	case requested > int64(max):
+		return max
+	}
+	return int(requested)
+}
+
+// setTimers sets renewal (58) and rebinding (59) times of the lease, subnet timers are used if lease has none.
+// Timers not less than lease time are skipped, as well as T1 not less than T2, so client uses its defaults
+func (s *Server) setTimers(resp *dhcpv4.DHCPv4, lease *Lease, subnet *Subnet) {
+	renewal, rebinding := lease.RenewalTime, lease.RebindingTime
+	if renewal.IsZero() {
+		renewal = subnet.RenewalTime
+	}
+	if rebinding.IsZero() {
+		rebinding = subnet.RebindingTime
+	}
+	t1 := renewal.seconds(lease.LeaseTime)
+	t2 := rebinding.seconds(lease.LeaseTime)
+	if t2 >= lease.LeaseTime {
+		t2 = 0
+	}
+	if t1 >= lease.LeaseTime || (t2 != 0 && t1 >= t2) {
+		t1 = 0
+	}
+	if t1 != 0 {
+		resp.UpdateOption(dhcpv4.Option{
+			Code:  dhcpv4.OptionRenewTimeValue,
+			Value: dhcpv4.Duration(time.Duration(t1) * time.Second),
+		})
+	}
+	if t2 != 0 {
+		resp.UpdateOption(dhcpv4.Option{
+			Code:  dhcpv4.OptionRebindingTimeValue,
+			Value: dhcpv4.Duration(time.Duration(t2) * time.Second),
+		})
+	}
+	if (t1 == 0 && !renewal.IsZero()) || (t2 == 0 && !rebinding.IsZero()) {
+		s.log.Debugf("Skipping timers T1 %s, T2 %s not fitting lease time %d of %s", renewal, rebinding,
+			lease.LeaseTime, lease.IP)
+	}
+}
@@ -0,0 +1,209 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	OptionTypeString     = "string"
+	OptionTypeIP         = "ip"
+	OptionTypeIPList     = "ip-list"
+	OptionTypeUint8      = "uint8"
+	OptionTypeUint16     = "uint16"
+	OptionTypeUint32     = "uint32"
+	OptionTypeInt32      = "int32"
+	OptionTypeBool       = "bool"
+	OptionTypeHex        = "hex"
+	OptionTypeBytes      = "bytes"
+	OptionTypeDomainList = "domain-list"
+	OptionTypeRoutes     = "routes"
+)
+
+// Validate returns error if option value can't be encoded according to its type.
+// Value of option with expression is known for the request only, so it isn't validated
+func (o Option) Validate() error {
+	if o.Expression != nil {
+		return nil
+	}
+	_, err := o.Encode()
+	return err
+}
+
+// ValidateOptions returns first invalid option error
+func ValidateOptions(opts []Option) error {
+	for _, opt := range opts {
+		if err := opt.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode returns option value in wire format.
+// List values (ip-list, domain-list, routes) are comma separated, routes are "10.0.0.0/8 10.1.1.1".
+func (o Option) Encode() ([]byte, error) {
+	data, err := encodeOptionValue(o.Type, o.Value)
+	if err != nil {
+		return nil, fmt.Errorf("option %d (%s): %w", o.ID, o.Type, err)
+	}
+	return data, nil
+}
+
+func encodeOptionValue(t string, value string) ([]byte, error) {
+	switch t {
+	case OptionTypeString:
+		return []byte(value), nil
+	case OptionTypeIP:
+		return parseIPv4(value)
+	case OptionTypeIPList:
+		var data []byte
+		for _, v := range splitList(value) {
+			ip, err := parseIPv4(v)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, ip...)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("empty ip list")
+		}
+		return data, nil
+	case OptionTypeUint8:
+		n, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{uint8(n)}, nil
+	case OptionTypeUint16:
+		n, err := strconv.ParseUint(value, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 2)
+		binary.BigEndian.PutUint16(data, uint16(n))
+		return data, nil
+	case OptionTypeUint32:
+		n, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(n))
+		return data, nil
+	case OptionTypeInt32:
+		n, err := strconv.ParseInt(value, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(int32(n)))
+		return data, nil
+	case OptionTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case OptionTypeHex, OptionTypeBytes:
+		s := strings.NewReplacer(":", "", " ", "", "-", "").Replace(value)
+		return hex.DecodeString(s)
+	case OptionTypeDomainList:
+		return encodeDomainList(splitList(value))
+	case OptionTypeRoutes:
+		return encodeClasslessRoutes(splitList(value))
+	default:
+		return nil, fmt.Errorf("unknown option type %q", t)
+	}
+}
+
+func splitList(value string) []string {
+	var rv []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			rv = append(rv, v)
+		}
+	}
+	return rv
+}
+
+func parseIPv4(value string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(value)).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ipv4 address %q", value)
+	}
+	return ip, nil
+}
+
+// encodeDomainList encodes domain search list (option 119) using RFC 1035 message compression
+func encodeDomainList(domains []string) ([]byte, error) {
+	var data []byte
+	offsets := map[string]int{}
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(domain, ".")
+		if len(domain) == 0 || len(domain) > 253 {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+		labels := strings.Split(domain, ".")
+		for i := range labels {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if offset, ok := offsets[suffix]; ok {
+				data = append(data, 0xc0|byte(offset>>8), byte(offset))
+				break
+			}
+			if len(labels[i]) == 0 || len(labels[i]) > 63 {
+				return nil, fmt.Errorf("invalid label %q in domain %q", labels[i], domain)
+			}
+			if len(data) <= 0x3fff {
+				offsets[suffix] = len(data)
+			}
+			data = append(data, byte(len(labels[i])))
+			data = append(data, labels[i]...)
+			if i == len(labels)-1 {
+				data = append(data, 0)
+			}
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty domain list")
+	}
+	return data, nil
+}
+
+// encodeClasslessRoutes encodes classless static routes (option 121, RFC 3442)
+func encodeClasslessRoutes(routes []string) ([]byte, error) {
+	var data []byte
+	for _, route := range routes {
+		fields := strings.Fields(route)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid route %q, expected \"<destination/prefix> <router>\"", route)
+		}
+		_, dest, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if dest.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid ipv4 destination %q", fields[0])
+		}
+		router, err := parseIPv4(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		ones, _ := dest.Mask.Size()
+		data = append(data, byte(ones))
+		data = append(data, dest.IP.To4()[:(ones+7)/8]...)
+		data = append(data, router...)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty route list")
+	}
+	return data, nil
+}
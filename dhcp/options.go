@@ -0,0 +1,125 @@
+package dhcp
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const (
+	dhcpHeaderLen = 240 //fixed header with magic cookie
+	bootpMinLen   = 300
+)
+
+// mandatoryOptions are sent regardless of client's parameter request list
+var mandatoryOptions = map[uint8]bool{
+	dhcpv4.OptionSubnetMask.Code():            true,
+	dhcpv4.OptionIPAddressLeaseTime.Code():    true,
+	dhcpv4.OptionDHCPMessageType.Code():       true,
+	dhcpv4.OptionServerIdentifier.Code():      true,
+	dhcpv4.OptionMessage.Code():               true,
+	dhcpv4.OptionRenewTimeValue.Code():        true,
+	dhcpv4.OptionRebindingTimeValue.Code():    true,
+	dhcpv4.OptionClientIdentifier.Code():      true,
+	dhcpv4.OptionRelayAgentInformation.Code(): true,
+	dhcpv4.OptionRapidCommit.Code():           true,
+	dhcpv4.OptionClassIdentifier.Code():       true,
+}
+
+// filterRequestedOptions removes options which client didn't ask for in parameter request list (option 55).
+// Mandatory options and options with AlwaysSend flag are kept. Nothing is removed if client sent no list.
+func filterRequestedOptions(req *dhcpv4.DHCPv4, resp *dhcpv4.DHCPv4, options []Option) {
+	if !req.Options.Has(dhcpv4.OptionParameterRequestList) {
+		return
+	}
+	keep := map[uint8]bool{}
+	for _, code := range req.ParameterRequestList() {
+		keep[code.Code()] = true
+	}
+	for _, opt := range options {
+		if opt.AlwaysSend {
+			keep[opt.ID] = true
+		}
+	}
+	for code := range resp.Options {
+		if !mandatoryOptions[code] && !keep[code] {
+			delete(resp.Options, code)
+		}
+	}
+}
+
+// encodeReply serializes response with options ordered as in client's parameter request list.
+// Message type goes first and options not in the list follow in ascending order.
+func encodeReply(req *dhcpv4.DHCPv4, resp *dhcpv4.DHCPv4) []byte {
+	first := []uint8{dhcpv4.OptionDHCPMessageType.Code()}
+	if req != nil {
+		for _, code := range req.ParameterRequestList() {
+			first = append(first, code.Code())
+		}
+	}
+	return encodeMessage(resp, first, nil)
+}
+
+// encodeRelayed serializes request forwarded by relay agent. Relay agent information goes last (RFC 3046 2.1)
+func encodeRelayed(req *dhcpv4.DHCPv4) []byte {
+	return encodeMessage(req, []uint8{dhcpv4.OptionDHCPMessageType.Code()},
+		[]uint8{dhcpv4.OptionRelayAgentInformation.Code()})
+}
+
+// encodeMessage serializes message with options in order of first codes, then the rest in ascending order except
+// of last codes which follow them
+func encodeMessage(msg *dhcpv4.DHCPv4, first []uint8, last []uint8) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, bootpMinLen))
+	buf.Write(msg.ToBytes()[:dhcpHeaderLen])
+
+	written := map[uint8]bool{}
+	for _, code := range last {
+		written[code] = true
+	}
+	writeOption := func(code uint8) {
+		data, ok := msg.Options[code]
+		if !ok || written[code] {
+			return
+		}
+		written[code] = true
+		//RFC 3396: long options are split into multiple instances
+		for {
+			n := len(data)
+			if n > 255 {
+				n = 255
+			}
+			buf.WriteByte(code)
+			buf.WriteByte(uint8(n))
+			buf.Write(data[:n])
+			data = data[n:]
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+
+	for _, code := range first {
+		writeOption(code)
+	}
+	var rest []int
+	for code := range msg.Options {
+		rest = append(rest, int(code))
+	}
+	sort.Ints(rest)
+	for _, code := range rest {
+		if code == int(dhcpv4.OptionEnd.Code()) || code == int(dhcpv4.OptionPad.Code()) {
+			continue
+		}
+		writeOption(uint8(code))
+	}
+	for _, code := range last {
+		written[code] = false
+		writeOption(code)
+	}
+	buf.WriteByte(dhcpv4.OptionEnd.Code())
+	for buf.Len() < bootpMinLen {
+		buf.WriteByte(dhcpv4.OptionPad.Code())
+	}
+	return buf.Bytes()
+}
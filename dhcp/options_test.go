@@ -0,0 +1,102 @@
+package dhcp
+
+import (
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestFilterRequestedOptions(t *testing.T) {
+	req, err := dhcpv4.New(dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer, dhcpv4.OptionRouter))
+	require.NoError(t, err)
+	resp, err := dhcpv4.New(
+		dhcpv4.WithOption(dhcpv4.OptSubnetMask(net.IPv4Mask(255, 255, 255, 0))),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.IP{10, 0, 0, 1})),
+		dhcpv4.WithOption(dhcpv4.OptDNS(net.IP{1, 1, 1, 1})),
+		dhcpv4.WithOption(dhcpv4.OptDomainName("example.net")),
+		dhcpv4.WithOption(dhcpv4.OptTFTPServerName("10.0.0.2")),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IP{10, 0, 0, 1})),
+	)
+	require.NoError(t, err)
+
+	filterRequestedOptions(req, resp, []Option{{ID: 66, Type: "string", Value: "10.0.0.2", AlwaysSend: true}})
+	require.True(t, resp.Options.Has(dhcpv4.OptionSubnetMask))
+	require.True(t, resp.Options.Has(dhcpv4.OptionRouter))
+	require.True(t, resp.Options.Has(dhcpv4.OptionDomainNameServer))
+	require.True(t, resp.Options.Has(dhcpv4.OptionServerIdentifier))
+	require.True(t, resp.Options.Has(dhcpv4.OptionTFTPServerName))
+	require.False(t, resp.Options.Has(dhcpv4.OptionDomainName))
+
+	//everything is sent if client has no parameter request list
+	delete(req.Options, dhcpv4.OptionParameterRequestList.Code())
+	resp.UpdateOption(dhcpv4.OptDomainName("example.net"))
+	filterRequestedOptions(req, resp, nil)
+	require.True(t, resp.Options.Has(dhcpv4.OptionDomainName))
+}
+
+func TestEncodeReply(t *testing.T) {
+	req, err := dhcpv4.New(dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer, dhcpv4.OptionRouter))
+	require.NoError(t, err)
+	resp, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithOption(dhcpv4.OptSubnetMask(net.IPv4Mask(255, 255, 255, 0))),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.IP{10, 0, 0, 1})),
+		dhcpv4.WithOption(dhcpv4.OptDNS(net.IP{1, 1, 1, 1})),
+	)
+	require.NoError(t, err)
+
+	data := encodeReply(req, resp)
+	require.GreaterOrEqual(t, len(data), bootpMinLen)
+	var codes []uint8
+	for i := dhcpHeaderLen; data[i] != dhcpv4.OptionEnd.Code(); i += 2 + int(data[i+1]) {
+		codes = append(codes, data[i])
+	}
+	require.Equal(t, []uint8{53, 6, 3, 1}, codes)
+
+	decoded, err := dhcpv4.FromBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, resp.Options, decoded.Options)
+}
+
+func TestOption_Encode(t *testing.T) {
+	for _, tc := range []struct {
+		opt      Option
+		expected []byte
+	}{
+		{Option{Type: "string", Value: "abc"}, []byte("abc")},
+		{Option{Type: "ip", Value: "10.0.0.1"}, []byte{10, 0, 0, 1}},
+		{Option{Type: "ip-list", Value: "10.0.0.1, 10.0.0.2"}, []byte{10, 0, 0, 1, 10, 0, 0, 2}},
+		{Option{Type: "uint8", Value: "200"}, []byte{200}},
+		{Option{Type: "uint16", Value: "1500"}, []byte{0x05, 0xdc}},
+		{Option{Type: "uint32", Value: "3600"}, []byte{0, 0, 0x0e, 0x10}},
+		{Option{Type: "int32", Value: "-1"}, []byte{0xff, 0xff, 0xff, 0xff}},
+		{Option{Type: "bool", Value: "true"}, []byte{1}},
+		{Option{Type: "hex", Value: "01:02:0a"}, []byte{1, 2, 10}},
+		{Option{Type: "bytes", Value: "01020a"}, []byte{1, 2, 10}},
+		{Option{Type: "domain-list", Value: "eng.apple.com,marketing.apple.com"}, []byte{
+			3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+			9, 'm', 'a', 'r', 'k', 'e', 't', 'i', 'n', 'g', 0xc0, 0x04}},
+		{Option{Type: "routes", Value: "10.0.0.0/8 10.1.1.1,0.0.0.0/0 10.1.1.254"}, []byte{
+			8, 10, 10, 1, 1, 1,
+			0, 10, 1, 1, 254}},
+	} {
+		data, err := tc.opt.Encode()
+		require.NoError(t, err, tc.opt.Type)
+		require.Equal(t, tc.expected, data, tc.opt.Type)
+	}
+
+	for _, opt := range []Option{
+		{Type: "unknown", Value: "abc"},
+		{Type: "ip", Value: "10.0.0"},
+		{Type: "ip-list", Value: ""},
+		{Type: "uint8", Value: "256"},
+		{Type: "int32", Value: "abc"},
+		{Type: "bool", Value: "yes please"},
+		{Type: "hex", Value: "0g"},
+		{Type: "domain-list", Value: "bad..domain"},
+		{Type: "routes", Value: "10.0.0.0/8"},
+	} {
+		require.Error(t, opt.Validate(), opt.Type)
+	}
+}
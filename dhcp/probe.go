@@ -0,0 +1,239 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// maxProbeAttempts is a number of addresses probed for a single DISCOVER before it is dropped
+const maxProbeAttempts = 8
+
+type ProbeMethod string
+
+const (
+	//ProbeMethodARP is used for directly attached subnets
+	ProbeMethodARP ProbeMethod = "arp"
+	//ProbeMethodICMP is used for relayed subnets
+	ProbeMethodICMP ProbeMethod = "icmp"
+)
+
+// Probe results as reported in metrics
+const (
+	probeResultFree     = "free"
+	probeResultConflict = "conflict"
+	probeResultError    = "error"
+)
+
+// Prober checks if address is in use before it is offered
+type Prober interface {
+	// Probe returns true if address answered. ARP prober returns hardware address of the responder as well
+	Probe(method ProbeMethod, ifName interfaceName, ip net.IP, timeout time.Duration) (bool, net.HardwareAddr, error)
+}
+
+var (
+	probesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhcp_conflict_probes_total",
+		Help: "Number of address conflict probes by subnet, method and result (free, conflict, error)",
+	}, []string{"subnet", "method", "result"})
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhcp_conflict_probe_duration_seconds",
+		Help:    "Duration of address conflict probes by subnet and method",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2},
+	}, []string{"subnet", "method"})
+)
+
+// Collectors returns prometheus metrics of the server to be registered by the caller
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{probesTotal, probeDuration}
+}
+
+// NetProber probes addresses by ARP requests (RFC 5227 probes) and ICMP echo requests.
+// It needs CAP_NET_RAW
+type NetProber struct{}
+
+func (p NetProber) Probe(method ProbeMethod, ifName interfaceName, ip net.IP, timeout time.Duration) (
+	bool, net.HardwareAddr, error) {
+	switch method {
+	case ProbeMethodARP:
+		hw, err := probeARP(ifName, ip, timeout)
+		return hw != nil, hw, err
+	case ProbeMethodICMP:
+		ok, err := probeICMP(ip, timeout)
+		return ok, nil, err
+	}
+	return false, nil, fmt.Errorf("unknown probe method %q", method)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// probeARP sends ARP probe and returns hardware address of the host which answered, or nil on timeout
+func probeARP(ifName interfaceName, ip net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	iface, err := net.InterfaceByName(string(ifName))
+	if err != nil {
+		return nil, err
+	}
+	proto := htons(syscall.ETH_P_ARP)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open socket: %v", err)
+	}
+	defer syscall.Close(fd)
+	addr := &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index, Halen: 6}
+	copy(addr.Addr[:], layers.EthernetBroadcast)
+	if err = syscall.Bind(fd, addr); err != nil {
+		return nil, fmt.Errorf("cannot bind socket: %v", err)
+	}
+
+	eth := layers.Ethernet{
+		SrcMAC:       iface.HardwareAddr,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	//probe has zero sender address, so it doesn't update arp caches
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   iface.HardwareAddr,
+		SourceProtAddress: net.IPv4zero.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    ip.To4(),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err = gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &arp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize arp probe: %v", err)
+	}
+	if err = syscall.Sendto(fd, buf.Bytes(), 0, addr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	packet := make([]byte, 1500)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, nil
+		}
+		tv := syscall.NsecToTimeval(left.Nanoseconds())
+		if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return nil, err
+		}
+		n, _, err := syscall.Recvfrom(fd, packet, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return nil, err
+		}
+		p := gopacket.NewPacket(packet[:n], layers.LayerTypeEthernet, gopacket.NoCopy)
+		reply, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+		if !ok {
+			continue
+		}
+		//reply or another host probing/announcing the same address
+		if net.IP(reply.SourceProtAddress).Equal(ip) {
+			return net.HardwareAddr(append([]byte{}, reply.SourceHwAddress...)), nil
+		}
+	}
+}
+
+// probeICMP sends ICMP echo request and returns true if the address replied
+func probeICMP(ip net.IP, timeout time.Duration) (bool, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	id := os.Getpid() & 0xffff
+	seq := rand.Intn(0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("k8s-dhcp")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err = conn.WriteTo(data, &net.IPAddr{IP: ip}); err != nil {
+		return false, err
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		reply, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if ok && echo.ID == id && echo.Seq == seq && src.(*net.IPAddr).IP.Equal(ip) {
+			return true, nil
+		}
+	}
+}
+
+// probeMethod returns method new address for the request should be probed with, or empty string if probing
+// is disabled. Directly attached clients are probed by ARP, relayed ones by ICMP
+func (s *Subnet) probeMethod(req Request) ProbeMethod {
+	if s.ProbeTimeout == 0 {
+		return ""
+	}
+	if isAddressZero(req.GatewayIPAddr) {
+		return ProbeMethodARP
+	}
+	return ProbeMethodICMP
+}
+
+// probeLease probes address of the lease and returns copy of conflicted lease to be saved if it is in use.
+// Nil is returned if address is free or probe failed
+func (s *Server) probeLease(req Request, resp Response) *Lease {
+	subnet := s.getSubnetForIp(resp.Lease.IP)
+	if subnet == nil {
+		return nil
+	}
+	method := subnet.probeMethod(req)
+	start := time.Now()
+	inUse, hwAddr, err := s.prober.Probe(method, req.InterfaceName, resp.Lease.IP, subnet.ProbeTimeout)
+	probeDuration.WithLabelValues(string(subnet.Subnet), string(method)).Observe(time.Since(start).Seconds())
+	result := probeResultFree
+	switch {
+	case err != nil:
+		result = probeResultError
+		s.log.Errorf(err, "failed to probe %s, offering it anyway", resp.Lease.IP)
+	case inUse:
+		result = probeResultConflict
+	}
+	probesTotal.WithLabelValues(string(subnet.Subnet), string(method), result).Inc()
+	if !inUse {
+		subnet.probeDone(resp.Lease)
+		return nil
+	}
+	s.log.Infof("Address %s is in use (%s probe, responder %s), not offering it to %s", resp.Lease.IP, method,
+		hwAddr, req.ClientHWAddr)
+	return subnet.markConflict(resp.Lease, method, hwAddr)
+}
@@ -0,0 +1,227 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// proxyDHCPPort is a port of PXE boot server discovery (PXE specification 2.1)
+const proxyDHCPPort = 4011
+
+// pxeClientClass is vendor class identifier (option 60) prefix of PXE clients, e.g. "PXEClient:Arch:00007:UNDI:003016"
+const pxeClientClass = "PXEClient"
+
+// PXE vendor sub-options (option 43)
+const (
+	pxeDiscoveryControl = 6
+	pxeBootItem         = 71
+	//pxeDiscoveryUseBootFile makes client download boot file of the offer, skipping boot server discovery
+	pxeDiscoveryUseBootFile = 0x08
+)
+
+// ProxyDHCPConfig makes DHCPv4 listener a proxyDHCP server: PXE clients get boot parameters from it,
+// while their addresses are assigned by another DHCP server
+type ProxyDHCPConfig struct {
+	BootFileName  string
+	BootProfiles  []BootProfile
+	ServerAddress net.IP //siaddr and server identifier, the address request is received at if nil
+}
+
+// ProxyProcessor is a proxyDHCP listener. PXE clients' DISCOVERs are answered with offers without address,
+// and boot server discovery requests are answered on port 4011. Leases are never allocated
+type ProxyProcessor struct {
+	socket     Socket
+	bootSocket Socket //boot server discovery, port 4011
+	config     ProxyDHCPConfig
+	server     *Server
+	log        RLogger
+}
+
+// ValidateProxyDHCPConfig returns error if proxyDHCP has nothing to boot
+func ValidateProxyDHCPConfig(proxy *ProxyDHCPConfig) error {
+	if proxy.BootFileName == "" && len(proxy.BootProfiles) == 0 {
+		return errors.New("proxyDHCP without boot file")
+	}
+	if proxy.ServerAddress != nil && proxy.ServerAddress.To4() == nil {
+		return fmt.Errorf("invalid proxyDHCP server address %s", proxy.ServerAddress)
+	}
+	return nil
+}
+
+func NewProxyProcessor(listen Listen,
+	socketFactory SocketFactory,
+	server *Server,
+	logger RLogger) (*ProxyProcessor, error) {
+	var err error
+	if err = ValidateProxyDHCPConfig(listen.ProxyDHCP); err != nil {
+		return nil, err
+	}
+	p := &ProxyProcessor{
+		config: *listen.ProxyDHCP,
+		server: server,
+		log:    logger.WithName(fmt.Sprintf("proxy[%s]", listen.ToString())),
+	}
+	p.socket, err = socketFactory(listen.Addr, listen.Interface, logger)
+	if err != nil {
+		return nil, err
+	}
+	bootAddr := fmt.Sprintf("%s:%d", strings.Split(listen.Addr, ":")[0], proxyDHCPPort)
+	p.bootSocket, err = socketFactory(bootAddr, listen.Interface, logger)
+	if err != nil {
+		p.socket.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Serve answers DISCOVERs on the DHCP port and boot server requests on port 4011 until sockets are closed
+func (p *ProxyProcessor) Serve() error {
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- serveSocket(p.socket, p.log, func(req *Request) {
+			p.handle(*req, false)
+		})
+	}()
+	go func() {
+		errChan <- serveSocket(p.bootSocket, p.log, func(req *Request) {
+			p.handle(*req, true)
+		})
+	}()
+	err := <-errChan
+	if err2 := <-errChan; err == nil {
+		err = err2
+	}
+	return err
+}
+
+// handle sends reply to the request received on DHCP port, or on boot server port if bootServer is set
+func (p *ProxyProcessor) handle(req Request, bootServer bool) {
+	resp, err := p.getResponse(req, bootServer)
+	if err != nil {
+		p.log.Errorf(err, "Failed to get response to request: %s", req.String())
+		return
+	}
+	if resp == nil {
+		return
+	}
+	if !bootServer {
+		//offer is broadcast to the client or sent to relay agent, as client has no address
+		req.socket = p.socket
+		response := Response{Request: req, Response: *resp}
+		err = response.Send()
+	} else {
+		//boot server replies to the port request is sent from
+		dst, ok := req.Src.(*net.UDPAddr)
+		if !ok || isAddressZero(dst.IP) {
+			dst = &net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort}
+		}
+		err = p.bootSocket.SendTo(req, *resp, dst)
+	}
+	if err != nil {
+		p.log.Errorf(err, "failed to send response to %s", req.ClientHWAddr)
+	}
+}
+
+// getResponse returns OFFER to DISCOVER received on DHCP port, or ACK to REQUEST or INFORM received on boot
+// server port. Nil is returned for requests of non-PXE clients, as they are served by another DHCP server
+func (p *ProxyProcessor) getResponse(req Request, bootServer bool) (*dhcpv4.DHCPv4, error) {
+	if !strings.HasPrefix(req.ClassIdentifier(), pxeClientClass) {
+		p.log.Debugf("Ignoring %s from %s: not PXE client", req.MessageType(), req.ClientHWAddr)
+		return nil, nil
+	}
+	var msgType dhcpv4.MessageType
+	switch {
+	case !bootServer && req.MessageType() == dhcpv4.MessageTypeDiscover:
+		msgType = dhcpv4.MessageTypeOffer
+	case bootServer && (req.MessageType() == dhcpv4.MessageTypeRequest ||
+		req.MessageType() == dhcpv4.MessageTypeInform):
+		msgType = dhcpv4.MessageTypeAck
+	default:
+		p.log.Debugf("Ignoring %s from %s", req.MessageType(), req.ClientHWAddr)
+		return nil, nil
+	}
+	serverAddr := p.serverAddress(req)
+	if serverAddr == nil {
+		return nil, fmt.Errorf("no server address on interface %s", req.InterfaceName)
+	}
+
+	resp, err := dhcpv4.NewReplyFromRequest(req.DHCPv4,
+		dhcpv4.WithMessageType(msgType),
+		dhcpv4.WithServerIP(serverAddr),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverAddr)),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(pxeClientClass)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if bootServer {
+		resp.ClientIPAddr = req.ClientIPAddr
+	}
+	resp.BootFileName = p.config.BootFileName
+	p.server.setBootParameters(req.DHCPv4, resp, &Lease{BootProfiles: p.config.BootProfiles})
+	if resp.BootFileName == "" {
+		p.log.Debugf("Ignoring %s from %s: no boot file for arch %v", req.MessageType(), req.ClientHWAddr,
+			req.ClientArch())
+		return nil, nil
+	}
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, pxeVendorOptions(req.DHCPv4)))
+	if uuid := req.Options.Get(dhcpv4.OptionClientMachineIdentifier); uuid != nil {
+		//some PXE ROMs ignore replies without client machine identifier they sent
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, uuid))
+	}
+	reply := "offer"
+	if bootServer {
+		reply = "boot server ack"
+	}
+	p.log.Infof("Sending proxyDHCP %s to %s: %s from %s", reply, req.ClientHWAddr, resp.BootFileName,
+		resp.ServerIPAddr)
+	return resp, nil
+}
+
+// serverAddress returns configured server address, the address unicast request is received at,
+// or the first address of receiving interface
+func (p *ProxyProcessor) serverAddress(req Request) net.IP {
+	if p.config.ServerAddress != nil {
+		return p.config.ServerAddress.To4()
+	}
+	if req.Dst != nil && !isAddressZero(req.Dst) && !req.Dst.Equal(net.IPv4bcast) {
+		return req.Dst.To4()
+	}
+	for _, ip := range p.server.localIpAddresses[req.InterfaceName] {
+		if ip.To4() != nil {
+			return ip.To4()
+		}
+	}
+	return nil
+}
+
+// pxeVendorOptions returns PXE sub-options (option 43) telling client to download boot file directly.
+// Boot item requested by client is echoed back
+func pxeVendorOptions(req *dhcpv4.DHCPv4) []byte {
+	data := []byte{pxeDiscoveryControl, 1, pxeDiscoveryUseBootFile}
+	opts := req.Options.Get(dhcpv4.OptionVendorSpecificInformation)
+	for len(opts) > 0 && opts[0] != 255 {
+		if opts[0] == 0 {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1])+2 > len(opts) {
+			break
+		}
+		length := int(opts[1])
+		if opts[0] == pxeBootItem {
+			data = append(data, opts[:length+2]...)
+		}
+		opts = opts[length+2:]
+	}
+	return append(data, 255)
+}
+
+func (p *ProxyProcessor) Close() {
+	p.socket.Close()
+	p.bootSocket.Close()
+}
@@ -0,0 +1,277 @@
+package dhcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// maxRelayHops is a number of relay agents request may pass, requests with more hops are dropped
+const maxRelayHops = 16
+
+// RelayConfig makes DHCPv4 listener a relay agent (RFC 3046): requests of clients on the listen interface are
+// forwarded to upstream servers, and their replies are sent back to clients
+type RelayConfig struct {
+	Upstreams      []string //server addresses, e.g. "10.0.0.1" or "10.0.0.1:67"
+	GatewayAddress string   //giaddr, the first address of listen interface if empty
+	CircuitID      string   //agent circuit id template (option 82 sub-option 1), e.g. "{{.Interface}}"
+	RemoteID       string   //agent remote id template (option 82 sub-option 2), e.g. "{{.Hostname}}"
+}
+
+// RelayTemplateData is available in circuit id and remote id templates
+type RelayTemplateData struct {
+	Interface      string //interface request is received on
+	MAC            string //client hardware address
+	GatewayAddress string //giaddr set by relay agent
+	Hostname       string //host name of the relay agent
+}
+
+// RelayProcessor is a listener relaying requests to upstream servers. Requests to upstreams which are addresses
+// of this server are processed in place, so they don't depend on routing of local traffic
+type RelayProcessor struct {
+	clientSocket   Socket
+	upstreamSocket Socket //bound to giaddr, upstream servers reply to it
+	upstreams      []*net.UDPAddr
+	local          *RequestProcessor
+	server         *Server
+	ifName         interfaceName
+	giaddr         net.IP
+	circuitID      *template.Template
+	remoteID       *template.Template
+	hostname       string
+	log            RLogger
+}
+
+func parseUpstream(upstream string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(upstream)
+	if err != nil {
+		host, port = upstream, strconv.Itoa(dhcpv4.ServerPort)
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid upstream address %q", upstream)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream port %q", upstream)
+	}
+	return &net.UDPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func parseRelayTemplate(name string, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+// ValidateRelayConfig returns error if upstreams or templates are invalid
+func ValidateRelayConfig(relay *RelayConfig) error {
+	if len(relay.Upstreams) == 0 {
+		return errors.New("relay without upstream servers")
+	}
+	for _, upstream := range relay.Upstreams {
+		if _, err := parseUpstream(upstream); err != nil {
+			return err
+		}
+	}
+	if relay.GatewayAddress != "" && net.ParseIP(relay.GatewayAddress).To4() == nil {
+		return fmt.Errorf("invalid gateway address %q", relay.GatewayAddress)
+	}
+	for name, text := range map[string]string{"circuit id": relay.CircuitID, "remote id": relay.RemoteID} {
+		if _, err := parseRelayTemplate(name, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewRelayProcessor(listen Listen,
+	socketFactory SocketFactory,
+	callbackSaveLeases CallbackSaveLeases,
+	server *Server,
+	logger RLogger) (*RelayProcessor, error) {
+	var err error
+	relay := listen.Relay
+	if listen.Interface == "" {
+		return nil, errors.New("relay requires listen interface")
+	}
+	if err = ValidateRelayConfig(relay); err != nil {
+		return nil, err
+	}
+	r := &RelayProcessor{
+		server: server,
+		ifName: interfaceName(listen.Interface),
+		log:    logger.WithName(fmt.Sprintf("relay[%s]", listen.ToString())),
+	}
+	for _, upstream := range relay.Upstreams {
+		addr, _ := parseUpstream(upstream)
+		r.upstreams = append(r.upstreams, addr)
+	}
+	r.circuitID, _ = parseRelayTemplate("circuit id", relay.CircuitID)
+	r.remoteID, _ = parseRelayTemplate("remote id", relay.RemoteID)
+	r.hostname, _ = os.Hostname()
+	r.giaddr = net.ParseIP(relay.GatewayAddress).To4()
+	if r.giaddr == nil {
+		for _, ip := range server.localIpAddresses[r.ifName] {
+			if ip.To4() != nil {
+				r.giaddr = ip.To4()
+				break
+			}
+		}
+	}
+	if r.giaddr == nil {
+		return nil, fmt.Errorf("no gateway address on interface %s", listen.Interface)
+	}
+	if net.ParseIP(listen.Addr).Equal(r.giaddr) {
+		return nil, fmt.Errorf("listen address must not be gateway address %s", r.giaddr)
+	}
+
+	r.clientSocket, err = socketFactory(listen.Addr, listen.Interface, logger)
+	if err != nil {
+		return nil, err
+	}
+	r.upstreamSocket, err = socketFactory(r.giaddr.String(), "", logger)
+	if err != nil {
+		r.clientSocket.Close()
+		return nil, err
+	}
+	r.local = newRequestProcessor(r.upstreamSocket, callbackSaveLeases, server, r.log.WithName("local"))
+	return r, nil
+}
+
+// Serve relays requests of clients and replies of upstream servers until sockets are closed
+func (r *RelayProcessor) Serve() error {
+	errChan := make(chan error, 2)
+	for _, socket := range []Socket{r.clientSocket, r.upstreamSocket} {
+		go func(socket Socket) {
+			errChan <- serveSocket(socket, r.log, r.relay)
+		}(socket)
+	}
+	err := <-errChan
+	if err2 := <-errChan; err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (r *RelayProcessor) relay(req *Request) {
+	if req.OpCode == dhcpv4.OpcodeBootReply {
+		r.relayReply(req.DHCPv4)
+		return
+	}
+	//requests are received from clients, or unicast by clients to giaddr (server identifier of local upstream)
+	r.relayRequest(*req)
+}
+
+// relayAgentInfo returns relay agent information option with circuit id and remote id of the request,
+// or nil if relay has neither of templates
+func (r *RelayProcessor) relayAgentInfo(req Request) (*dhcpv4.Option, error) {
+	data := RelayTemplateData{
+		Interface:      string(req.InterfaceName),
+		MAC:            req.ClientHWAddr.String(),
+		GatewayAddress: r.giaddr.String(),
+		Hostname:       r.hostname,
+	}
+	var subOptions []dhcpv4.Option
+	for _, sub := range []struct {
+		code dhcpv4.OptionCode
+		tmpl *template.Template
+	}{
+		{dhcpv4.AgentCircuitIDSubOption, r.circuitID},
+		{dhcpv4.AgentRemoteIDSubOption, r.remoteID},
+	} {
+		if sub.tmpl == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := sub.tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		if buf.Len() == 0 || buf.Len() > 255 {
+			return nil, fmt.Errorf("%s length %d is out of range 1-255", sub.tmpl.Name(), buf.Len())
+		}
+		subOptions = append(subOptions, dhcpv4.OptGeneric(sub.code, buf.Bytes()))
+	}
+	if len(subOptions) == 0 {
+		return nil, nil
+	}
+	opt := dhcpv4.OptRelayAgentInfo(subOptions...)
+	return &opt, nil
+}
+
+// relayRequest sets giaddr and relay agent information of the request and forwards it to upstream servers.
+// Requests relayed by another agent already are forwarded as is
+func (r *RelayProcessor) relayRequest(req Request) {
+	msg := req.DHCPv4
+	if msg.HopCount >= maxRelayHops {
+		r.log.Infof("Dropping request from %s: %d hops", msg.ClientHWAddr, msg.HopCount)
+		return
+	}
+	msg.HopCount++
+	if isAddressZero(msg.GatewayIPAddr) {
+		if msg.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+			//RFC 3046 2.1.1: option 82 without giaddr is inserted by untrusted client
+			r.log.Infof("Dropping request from %s with relay agent information and no giaddr", msg.ClientHWAddr)
+			return
+		}
+		msg.GatewayIPAddr = r.giaddr
+		rai, err := r.relayAgentInfo(req)
+		if err != nil {
+			r.log.Errorf(err, "Dropping request from %s", msg.ClientHWAddr)
+			return
+		}
+		if rai != nil {
+			msg.UpdateOption(*rai)
+		}
+	}
+	localSent := false
+	for _, upstream := range r.upstreams {
+		if r.server.serverIds[upstream.IP.String()] {
+			if !localSent {
+				localSent = true
+				r.local.enqueue(Request{
+					DHCPv4:        msg,
+					Src:           &net.UDPAddr{IP: r.giaddr, Port: dhcpv4.ServerPort},
+					Dst:           upstream.IP,
+					InterfaceName: req.InterfaceName,
+					socket:        r.upstreamSocket,
+				})
+			}
+			continue
+		}
+		r.log.Debugf("Relaying %s from %s to %s", msg.MessageType(), msg.ClientHWAddr, upstream)
+		if err := r.upstreamSocket.SendRelayed(msg, upstream); err != nil {
+			r.log.Errorf(err, "failed to relay request to %s", upstream)
+		}
+	}
+}
+
+// relayReply sends reply of upstream server to the client, without relay agent information
+func (r *RelayProcessor) relayReply(reply *dhcpv4.DHCPv4) {
+	if !reply.GatewayIPAddr.Equal(r.giaddr) {
+		r.log.Debugf("Ignoring reply to %s for giaddr %s", reply.ClientHWAddr, reply.GatewayIPAddr)
+		return
+	}
+	delete(reply.Options, dhcpv4.OptionRelayAgentInformation.Code())
+	req := Request{DHCPv4: reply, InterfaceName: r.ifName, socket: r.clientSocket}
+	if err := r.clientSocket.SendBroadcast(req, *reply); err != nil {
+		r.log.Errorf(err, "failed to relay reply to %s", reply.ClientHWAddr)
+	}
+}
+
+func (r *RelayProcessor) Close() {
+	r.clientSocket.Close()
+	r.upstreamSocket.Close()
+	r.local.stop()
+}
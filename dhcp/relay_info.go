@@ -0,0 +1,49 @@
+package dhcp
+
+import (
+	"net"
+	"unicode"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// parseRelayOptions fills relay agent information (option 82) and subnet selection (option 118) of the request.
+// Option 82 of request which was not relayed is inserted by untrusted client (RFC 3046 2.1.1), so it is dropped
+func parseRelayOptions(req *Request) {
+	if ip := dhcpv4.GetIP(dhcpv4.OptionSubnetSelection, req.Options); ip != nil {
+		req.SubnetSelection = ip
+	}
+	if isAddressZero(req.GatewayIPAddr) {
+		delete(req.Options, dhcpv4.OptionRelayAgentInformation.Code())
+		return
+	}
+	rai := req.RelayAgentInfo()
+	if rai == nil {
+		return
+	}
+	req.RelayInfo = &RelayAgentInfo{
+		CircuitID: rai.Get(dhcpv4.AgentCircuitIDSubOption),
+		RemoteID:  rai.Get(dhcpv4.AgentRemoteIDSubOption),
+	}
+	if ls := rai.Get(dhcpv4.LinkSelectionSubOption); len(ls) == net.IPv4len {
+		req.RelayInfo.LinkSelection = net.IP(ls)
+	}
+}
+
+// formatRelayID returns printable ids as is, others as colon separated hex
+func formatRelayID(id []byte) string {
+	if len(id) == 0 {
+		return ""
+	}
+	printable := true
+	for _, r := range string(id) {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return string(id)
+	}
+	return formatHex(id)
+}
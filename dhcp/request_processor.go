@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"net"
+	"sync"
 )
 
 const dhcpRequestChanBufSize = 1024
@@ -17,6 +18,8 @@ type RequestProcessor struct {
 	server             *Server
 	callbackSaveLeases CallbackSaveLeases
 	log                RLogger
+	done               chan struct{} //closed to stop workers
+	stopOnce           sync.Once
 }
 
 func NewRequestProcessor(listen Listen,
@@ -24,20 +27,27 @@ func NewRequestProcessor(listen Listen,
 	callbackSaveLeases CallbackSaveLeases,
 	server *Server,
 	logger RLogger) (*RequestProcessor, error) {
-	var err error
+	socket, err := socketFactory(listen.Addr, listen.Interface, logger)
+	if err != nil {
+		return nil, err
+	}
 	listenerName := fmt.Sprintf("listener[%s]", listen.ToString())
+	return newRequestProcessor(socket, callbackSaveLeases, server, logger.WithName(listenerName)), nil
+}
+
+// newRequestProcessor starts processing of requests sent to dhcpRequestChan, replies are sent through the socket
+func newRequestProcessor(socket Socket, callbackSaveLeases CallbackSaveLeases, server *Server,
+	logger RLogger) *RequestProcessor {
 	l := &RequestProcessor{
+		socket:             socket,
 		dhcpRequestChan:    make(chan Request, dhcpRequestChanBufSize),
 		callbackSaveLeases: callbackSaveLeases,
-		log:                logger.WithName(listenerName),
+		log:                logger,
 		server:             server,
-	}
-	l.socket, err = socketFactory(listen.Addr, listen.Interface, logger)
-	if err != nil {
-		return nil, err
+		done:               make(chan struct{}),
 	}
 	l.startRequestProcessors()
-	return l, nil
+	return l
 }
 
 func (s *RequestProcessor) runResponseProcessor(responseChan <-chan Response) {
@@ -63,6 +73,9 @@ func (s *RequestProcessor) runResponseProcessor(responseChan <-chan Response) {
 					break
 				}
 				for _, response = range responses {
+					if !response.HasReply() {
+						continue
+					}
 					err = response.Send()
 					if err != nil {
 						s.log.Errorf(err, "failed to send response: %s", response)
@@ -71,8 +84,12 @@ func (s *RequestProcessor) runResponseProcessor(responseChan <-chan Response) {
 			} else {
 				s.log.Debugf("empty response queue")
 			}
-			response, more = <-responseChan
-			if !more {
+			select {
+			case response, more = <-responseChan:
+				if !more {
+					return
+				}
+			case <-s.done:
 				return
 			}
 			responses = []Response{response}
@@ -92,7 +109,12 @@ func (s *RequestProcessor) runRequestProcessor() {
 	go s.runResponseProcessor(responseChan)
 	s.log.Debugf("Started worker")
 	for {
-		req, more = <-s.dhcpRequestChan
+		select {
+		case req, more = <-s.dhcpRequestChan:
+		case <-s.done:
+			s.log.Infof("Listener closed. Exiting worker")
+			return
+		}
 		if !more {
 			s.log.Infof("No more packets. Exiting worker")
 			close(responseChan)
@@ -102,19 +124,69 @@ func (s *RequestProcessor) runRequestProcessor() {
 		if err != nil {
 			s.log.Errorf(err, "Failed to get response to request: %s", req.String())
 			continue
-		} else {
-			//TODO send NAK and continue
-			switch resp.Response.MessageType() {
-			case dhcpv4.MessageTypeOffer:
-				resp.Lease.AckSent = false
-				responseChan <- resp
-			case dhcpv4.MessageTypeAck:
-				resp.Lease.AckSent = true
-				responseChan <- resp
-			default:
-				s.log.Infof("unknown response type: %s", resp.Response.String())
-			}
 		}
+		if resp.needsProbe {
+			//probe in background, so other requests are not stalled
+			go s.probeAndQueue(resp, responseChan)
+			continue
+		}
+		s.queueResponse(resp, responseChan)
+	}
+}
+
+// queueResponse sends response right away if there is no lease to save, or queues it to be sent after save
+func (s *RequestProcessor) queueResponse(resp Response, responseChan chan<- Response) {
+	if resp.Lease == nil {
+		//nothing to save (NAK, ACK to INFORM)
+		err := resp.Send()
+		if err != nil {
+			s.log.Errorf(err, "failed to send response: %s", resp.Response.String())
+		}
+		return
+	}
+	switch resp.Response.MessageType() {
+	case dhcpv4.MessageTypeOffer, dhcpv4.MessageTypeAck:
+		s.queueSave(resp, responseChan)
+	case dhcpv4.MessageTypeNone:
+		//no reply, but lease must be saved (e.g. release)
+		s.queueSave(resp, responseChan)
+	default:
+		s.log.Infof("unknown response type: %s", resp.Response.String())
+	}
+}
+
+// probeAndQueue queues response if its address is free. Otherwise conflict is saved and request is handled
+// again, so the next free address is offered and probed
+func (s *RequestProcessor) probeAndQueue(resp Response, responseChan chan<- Response) {
+	conflict := s.server.probeLease(resp.Request, resp)
+	if conflict == nil {
+		s.queueResponse(resp, responseChan)
+		return
+	}
+	s.queueSave(Response{Request: resp.Request, Lease: conflict}, responseChan)
+	req := resp.Request
+	req.probeAttempts++
+	if req.probeAttempts >= maxProbeAttempts {
+		s.log.Infof("Dropping request from %s: %d offered addresses are in use", req.ClientHWAddr,
+			req.probeAttempts)
+		return
+	}
+	s.enqueue(req)
+}
+
+// queueSave queues response to be saved and sent, unless processor is closed
+func (s *RequestProcessor) queueSave(resp Response, responseChan chan<- Response) {
+	select {
+	case responseChan <- resp:
+	case <-s.done:
+	}
+}
+
+// enqueue queues request to be processed, unless processor is closed
+func (s *RequestProcessor) enqueue(req Request) {
+	select {
+	case s.dhcpRequestChan <- req:
+	case <-s.done:
 	}
 }
 
@@ -138,19 +210,62 @@ func (s *RequestProcessor) Serve() error {
 				return e
 			}
 		} else {
-			s.dhcpRequestChan <- *req
+			s.enqueue(*req)
 		}
 	}
 }
 
+// replyTarget is destination of DHCPv4 reply
+type replyTarget int
+
+const (
+	replyToRelay        replyTarget = iota //giaddr, server port
+	replyToClientAddr                      //ciaddr, client port
+	replyBroadcast                         //255.255.255.255 and broadcast hardware address
+	replyToClientHWAddr                    //yiaddr and chaddr, client is not configured yet
+)
+
+// target returns destination of the reply by RFC 2131 4.1. ACK to INFORM is unicast to ciaddr even if
+// request was relayed (4.3.5), and NAK is always broadcast by server or relay agent
+func (s *Response) target() replyTarget {
+	req := s.Request
+	if req.MessageType() == dhcpv4.MessageTypeInform && !isAddressZero(req.ClientIPAddr) {
+		return replyToClientAddr
+	}
+	switch {
+	case !isAddressZero(req.GatewayIPAddr):
+		return replyToRelay
+	case s.Response.MessageType() == dhcpv4.MessageTypeNak:
+		return replyBroadcast
+	case !isAddressZero(req.ClientIPAddr):
+		return replyToClientAddr
+	case req.IsBroadcast() || isAddressZero(s.Response.YourIPAddr):
+		return replyBroadcast
+	}
+	return replyToClientHWAddr
+}
+
+// Send sends response to relay agent or to the client, see target
 func (s *Response) Send() error {
-	if isAddressZero(s.Request.GatewayIPAddr) {
-		return s.Request.socket.SendBroadcast(s.Request, s.Response)
+	switch s.target() {
+	case replyToRelay:
+		return s.Request.socket.SendResponse(s.Request, s.Response)
+	case replyToClientAddr:
+		return s.Request.socket.SendTo(s.Request, s.Response, &net.UDPAddr{
+			IP:   s.Request.ClientIPAddr,
+			Port: dhcpv4.ClientPort,
+		})
 	}
-	return s.Request.socket.SendResponse(s.Request, s.Response)
+	//client has no address yet, so the reply is sent as raw frame, broadcast if client asked for it
+	return s.Request.socket.SendBroadcast(s.Request, s.Response)
 }
 
 func (s *RequestProcessor) Close() {
 	s.socket.Close()
-	//TODO: close responders
+	s.stop()
+}
+
+// stop stops workers, requests and responses queued are dropped
+func (s *RequestProcessor) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
 }
@@ -0,0 +1,97 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// RequestProcessor6 handles DHCPv6 messages received on a listener
+type RequestProcessor6 struct {
+	socket             Socket6
+	dhcpRequestChan    chan Request6
+	server             *Server
+	callbackSaveLeases CallbackSaveLeases
+	log                RLogger
+}
+
+func NewRequestProcessor6(listen Listen,
+	socketFactory Socket6Factory,
+	callbackSaveLeases CallbackSaveLeases,
+	server *Server,
+	logger RLogger) (*RequestProcessor6, error) {
+	var err error
+	listenerName := fmt.Sprintf("listener6[%s]", listen.ToString())
+	l := &RequestProcessor6{
+		dhcpRequestChan:    make(chan Request6, dhcpRequestChanBufSize),
+		callbackSaveLeases: callbackSaveLeases,
+		log:                logger.WithName(listenerName),
+		server:             server,
+	}
+	l.socket, err = socketFactory(listen.Addr, listen.Interface, logger)
+	if err != nil {
+		return nil, err
+	}
+	go l.runRequestProcessor()
+	return l, nil
+}
+
+func (s *RequestProcessor6) runRequestProcessor() {
+	s.log.Debugf("Started worker")
+	for req := range s.dhcpRequestChan {
+		resp, err := s.server.GetResponse6(req)
+		if err != nil {
+			s.log.Errorf(err, "Failed to get response to request: %s", req.String())
+			continue
+		}
+		if len(resp.Leases) > 0 {
+			responses := make([]Response, 0, len(resp.Leases))
+			for _, lease := range resp.Leases {
+				responses = append(responses, Response{Lease: lease})
+			}
+			err = s.callbackSaveLeases(responses)
+			if err != nil {
+				s.log.Errorf(err, "failed to save %d leases", len(responses))
+				continue
+			}
+		}
+		if resp.Response == nil {
+			continue
+		}
+		err = resp.Send()
+		if err != nil {
+			s.log.Errorf(err, "failed to send response: %s", resp.Response.String())
+		}
+	}
+	s.log.Infof("No more packets. Exiting worker")
+}
+
+func (s *RequestProcessor6) Serve() error {
+	for {
+		req, err := s.socket.NextRequest()
+		if err != nil {
+			var e *net.OpError
+			if errors.As(err, &e) && errors.Is(e.Err, net.ErrClosed) {
+				s.log.Infof("Connection closed. Stopping server.")
+				close(s.dhcpRequestChan)
+				return nil
+			}
+			s.log.Errorf(err, "Error reading packet")
+			if e != nil && !e.Temporary() {
+				close(s.dhcpRequestChan)
+				return err
+			}
+			continue
+		}
+		s.dhcpRequestChan <- *req
+	}
+}
+
+func (s *RequestProcessor6) Close() {
+	s.socket.Close()
+}
+
+// Send replies to the source of request, which is either client or relay agent
+func (s *Response6) Send() error {
+	return s.Request.socket.SendTo(s.Response, s.Request.Src)
+}
@@ -0,0 +1,52 @@
+package dhcp
+
+import (
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"net"
+)
+
+// RequestState is a client state DHCPREQUEST is sent in (RFC 2131, 4.3.2)
+type RequestState string
+
+const (
+	//RequestStateSelecting is a response to OFFER: server identifier and requested address are set
+	RequestStateSelecting RequestState = "SELECTING"
+	//RequestStateInitReboot verifies previously allocated address: requested address is set, ciaddr is zero
+	RequestStateInitReboot RequestState = "INIT-REBOOT"
+	//RequestStateRenewing extends lease, request is unicast to the server: ciaddr is set
+	RequestStateRenewing RequestState = "RENEWING"
+	//RequestStateRebinding extends lease, request is broadcast to any server: ciaddr is set
+	RequestStateRebinding RequestState = "REBINDING"
+)
+
+// requestState returns state DHCPREQUEST is sent in, or empty string for other messages
+func requestState(req Request) RequestState {
+	if req.MessageType() != dhcpv4.MessageTypeRequest {
+		return ""
+	}
+	switch {
+	case req.Options.Has(dhcpv4.OptionServerIdentifier):
+		return RequestStateSelecting
+	case isAddressZero(req.ClientIPAddr):
+		return RequestStateInitReboot
+	case req.Dst != nil && !req.Dst.Equal(net.IPv4bcast):
+		return RequestStateRenewing
+	}
+	return RequestStateRebinding
+}
+
+// isRenewal returns true if client extends lease it is already configured with (RENEWING or REBINDING)
+func isRenewal(req *dhcpv4.DHCPv4) bool {
+	return req.MessageType() == dhcpv4.MessageTypeRequest &&
+		!req.Options.Has(dhcpv4.OptionServerIdentifier) &&
+		!isAddressZero(req.ClientIPAddr)
+}
+
+// clientRequestedAddress returns address requested by the client: ciaddr of renewing or rebinding client,
+// requested address option (50) otherwise
+func clientRequestedAddress(req *dhcpv4.DHCPv4) net.IP {
+	if isRenewal(req) {
+		return req.ClientIPAddr
+	}
+	return req.RequestedIPAddress()
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"log"
 	"net"
 	"sync"
@@ -13,41 +14,71 @@ import (
 
 const mirantisEntID = 45176
 
+const (
+	leaseReaperInterval    = time.Minute
+	leaseExpiryGracePeriod = time.Minute * 5
+)
+
 type LocalIPAddresses map[interfaceName][]net.IP
 
 type ServerConfig struct {
 	SocketFactory        SocketFactory
+	Socket6Factory       Socket6Factory
+	DUID                 *dhcpv6.Duid //DHCPv6 server id, derived from hardware address if not set
 	LocalAddressesGetter func() (LocalIPAddresses, error)
 	Logger               RLogger
 	CallbackSaveLeases   CallbackSaveLeases
+	Prober               Prober //address conflict prober, NetProber if not set
+	IPXEScriptPort       int    //port of HTTP boot endpoint, iPXE scripts are not offered if zero
+	EmbeddedTFTP         bool   //embedded TFTP server is running, subnets with EmbeddedTFTP are rejected otherwise
 	Context              context.Context
 }
 
 func NewServer(c ServerConfig) (*Server, error) {
 	var err error
 	server := &Server{
-		listeners: map[string]*RequestProcessor{},
+		listeners: map[string]listener{},
 		subnets:   map[SubnetAddrPrefix]*Subnet{},
+		subnets6:  map[SubnetAddrPrefix]*Subnet6{},
+		classes:   map[string]*ClientClass{},
 		context:   c.Context,
 	}
 	if c.SocketFactory == nil {
 		c.SocketFactory = NewUDPSocket
 	}
+	if c.Socket6Factory == nil {
+		c.Socket6Factory = NewUDPSocket6
+	}
+	if c.DUID == nil {
+		duid := defaultServerDUID()
+		c.DUID = &duid
+	}
+	if c.Prober == nil {
+		c.Prober = NetProber{}
+	}
 	if c.LocalAddressesGetter == nil {
 		c.LocalAddressesGetter = GetLocalAddresses
 	}
 	if c.CallbackSaveLeases == nil {
 		return nil, errors.New("CallbackSaveLeases is mandatory")
 	}
+	if server.context == nil {
+		server.context = context.Background()
+	}
 
 	if c.Logger == nil {
 		log.Fatal("no logger set")
 	}
 	server.log = c.Logger.WithName("dhcp.server")
 	server.socketFactory = c.SocketFactory
+	server.socket6Factory = c.Socket6Factory
+	server.duid = *c.DUID
 	server.subnetMutex = &sync.Mutex{}
 	server.listenMutex = &sync.Mutex{}
 	server.callbackSaveLeases = c.CallbackSaveLeases
+	server.prober = c.Prober
+	server.ipxeScriptPort = c.IPXEScriptPort
+	server.embeddedTFTP = c.EmbeddedTFTP
 	server.localIpAddresses, err = c.LocalAddressesGetter()
 	server.serverIds = map[string]bool{}
 	for _, lIPs := range server.localIpAddresses {
@@ -59,7 +90,7 @@ func NewServer(c ServerConfig) (*Server, error) {
 }
 
 func (s *Server) AddListen(listen Listen) error {
-	var requestProcessor *RequestProcessor
+	var requestProcessor listener
 	var err error
 	var ok bool
 
@@ -68,13 +99,47 @@ func (s *Server) AddListen(listen Listen) error {
 		return fmt.Errorf("requestProcessor %v already exists", listen)
 	}
 
-	s.log.Infof("Listening %s", listen.ToString())
+	s.log.Infof("Listening %s %s", listen.Protocol, listen.ToString())
 
-	requestProcessor, err = NewRequestProcessor(listen,
-		s.socketFactory,
-		s.callbackSaveLeases,
-		s,
-		s.log)
+	switch listen.Protocol {
+	case "", ProtocolDHCPv4:
+		if listen.Relay != nil && listen.ProxyDHCP != nil {
+			err = errors.New("listener can't be both relay and proxyDHCP")
+			break
+		}
+		if listen.ProxyDHCP != nil {
+			requestProcessor, err = NewProxyProcessor(listen,
+				s.socketFactory,
+				s,
+				s.log)
+			break
+		}
+		if listen.Relay != nil {
+			requestProcessor, err = NewRelayProcessor(listen,
+				s.socketFactory,
+				s.callbackSaveLeases,
+				s,
+				s.log)
+			break
+		}
+		requestProcessor, err = NewRequestProcessor(listen,
+			s.socketFactory,
+			s.callbackSaveLeases,
+			s,
+			s.log)
+	case ProtocolDHCPv6:
+		if listen.Relay != nil || listen.ProxyDHCP != nil {
+			err = errors.New("relay and proxyDHCP are supported for DHCPv4 only")
+			break
+		}
+		requestProcessor, err = NewRequestProcessor6(listen,
+			s.socket6Factory,
+			s.callbackSaveLeases,
+			s,
+			s.log)
+	default:
+		err = fmt.Errorf("unknown protocol %q", listen.Protocol)
+	}
 
 	if err != nil {
 		return err
@@ -89,7 +154,14 @@ func (s *Server) AddListen(listen Listen) error {
 	return nil
 }
 
+// AddSubnet adds DHCPv4 subnet, or DHCPv6 one if subnet prefix is IPv6
 func (s *Server) AddSubnet(subnet Subnet) error {
+	if isIPv6Prefix(subnet.Subnet) {
+		return s.addSubnet6(newSubnet6(subnet))
+	}
+	if subnet.EmbeddedTFTP && !s.embeddedTFTP {
+		return fmt.Errorf("subnet %s uses embedded TFTP server, which is disabled", subnet.Subnet)
+	}
 	err := InitializeSubnet(&subnet, s.localIpAddresses)
 	if err != nil {
 		return err
@@ -102,17 +174,56 @@ func (s *Server) AddSubnet(subnet Subnet) error {
 			return fmt.Errorf("overlapping subnets: %s, %s", sn.Subnet, subnet.Subnet)
 		}
 	}
+	var ctx context.Context
+	ctx, subnet.stopReaper = context.WithCancel(s.context)
 	s.subnets[subnet.Subnet] = &subnet
+	go s.runLeaseReaper(ctx, subnet.Subnet, &subnet)
 	return nil
 }
 
+type leaseReaper interface {
+	ReapExpiredLeases() []*Lease
+}
+
+func (s *Server) runLeaseReaper(ctx context.Context, prefix SubnetAddrPrefix, subnet leaseReaper) {
+	ticker := time.NewTicker(leaseReaperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired := subnet.ReapExpiredLeases()
+			if len(expired) == 0 {
+				continue
+			}
+			s.log.Infof("Removed %d expired leases in subnet %s", len(expired), prefix)
+			responses := make([]Response, 0, len(expired))
+			for _, lease := range expired {
+				responses = append(responses, Response{Lease: lease})
+			}
+			err := s.callbackSaveLeases(responses)
+			if err != nil {
+				s.log.Errorf(err, "failed to save expired leases in subnet %s", prefix)
+			}
+		}
+	}
+}
+
 func (s *Server) DeleteSubnet(subnet SubnetAddrPrefix) error {
 	s.subnetMutex.Lock()
 	defer s.subnetMutex.Unlock()
-	_, ok := s.subnets[subnet]
+	if sn6, ok := s.subnets6[subnet]; ok {
+		sn6.stopReaper()
+		delete(s.subnets6, subnet)
+		s.log.Infof("Deleted subnet %s", subnet)
+		return nil
+	}
+	sn, ok := s.subnets[subnet]
 	if !ok {
 		return fmt.Errorf("subnet %s not found", subnet)
 	}
+	sn.stopReaper()
 	delete(s.subnets, subnet)
 	s.log.Infof("Deleted subnet %s", subnet)
 	return nil
@@ -146,6 +257,12 @@ func (s *Server) AddHost(host Host) error {
 			return nil
 		}
 	}
+	for _, sn := range s.subnets6 {
+		if sn.hasHost(host) {
+			sn.AddHost(host)
+			return nil
+		}
+	}
 	return fmt.Errorf("can't find subnet for host: %s (%s)", host.IP, host.MAC)
 }
 
@@ -158,6 +275,12 @@ func (s *Server) DeleteHost(host Host) error {
 			return nil
 		}
 	}
+	for _, sn := range s.subnets6 {
+		if sn.hasHost(host) {
+			sn.DeleteHost(host)
+			return nil
+		}
+	}
 	return fmt.Errorf("host not found: %v", host)
 }
 
@@ -177,6 +300,14 @@ func (s *Server) Close() {
 	for _, l := range s.listeners {
 		l.Close()
 	}
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	for _, sn := range s.subnets {
+		sn.stopReaper()
+	}
+	for _, sn := range s.subnets6 {
+		sn.stopReaper()
+	}
 }
 
 func (s *Server) getSubnetForIp(ip net.IP) *Subnet {
@@ -194,6 +325,19 @@ func (s *Server) getSubnetForIp(ip net.IP) *Subnet {
 func (s *Server) getSubnet(req Request) *Subnet {
 	var sn *Subnet
 	var ip net.IP
+	if req.RelayInfo != nil && !isAddressZero(req.RelayInfo.LinkSelection) {
+		s.log.Debugf("Handling request with link selection %s", req.RelayInfo.LinkSelection)
+		return s.getSubnetForIp(req.RelayInfo.LinkSelection)
+	}
+	if !isAddressZero(req.SubnetSelection) {
+		s.log.Debugf("Handling request with subnet selection %s", req.SubnetSelection)
+		return s.getSubnetForIp(req.SubnetSelection)
+	}
+	if isAddressZero(req.GatewayIPAddr) && isRenewal(req.DHCPv4) {
+		//renewing client is configured already, and may be unicasting from another network
+		s.log.Debugf("Handling %s request from %s", requestState(req), req.ClientIPAddr)
+		return s.getSubnetForIp(req.ClientIPAddr)
+	}
 	if req.GatewayIPAddr == nil || req.GatewayIPAddr.Equal(net.IPv4zero) {
 		s.log.Debugf("Handling broadcast request on %s", req.InterfaceName)
 		for _, ip = range s.localIpAddresses[req.InterfaceName] {
@@ -218,45 +362,153 @@ func (s *Server) GetResponse(req Request) (Response, error) {
 		response Response
 		err      error
 	)
-	if req.ServerIdentifier() != nil && !req.ServerIdentifier().Equal(net.IPv4zero) {
-		if _, ok := s.serverIds[req.ServerIdentifier().String()]; !ok {
-			return response, fmt.Errorf("request for unknown server id: %s", req.ServerIdentifier().String())
+	parseRelayOptions(&req)
+	state := requestState(req)
+	if serverID := req.ServerIdentifier(); !isAddressZero(serverID) && !s.serverIds[serverID.String()] {
+		if state == RequestStateSelecting {
+			return response, fmt.Errorf("%s selected offer of server %s", req.ClientHWAddr, serverID)
+		}
+		return response, fmt.Errorf("request for unknown server id: %s", serverID)
+	}
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease:
+		response.Request = req
+		response.Lease, err = s.releaseLease(req)
+		return response, err
+	case dhcpv4.MessageTypeDecline:
+		response.Request = req
+		response.Lease, err = s.declineLease(req)
+		return response, err
+	case dhcpv4.MessageTypeInform:
+		resp, err = s.getInformResponse(req)
+		if err != nil {
+			return response, err
 		}
+		response.Request = req
+		response.Response = *resp
+		return response, nil
 	}
 	sn := s.getSubnet(req)
 	if sn == nil {
 		return response, fmt.Errorf("unknown subnet %s %s %s", req.Src, req.GatewayIPAddr, req.InterfaceName)
 	}
+	if err = s.matchSubnet(req, sn); err != nil {
+		return response, err
+	}
+	if state == RequestStateInitReboot && sn.Contains(req.RequestedIPAddress()) && !sn.knowsClient(req.DHCPv4) {
+		//server without record of the client must remain silent, so server which has it answers (RFC 2131 4.3.2)
+		return response, fmt.Errorf("%s request from unknown client %s", state, req.ClientHWAddr)
+	}
 	switch req.MessageType() {
 	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
 		resp, lease, err = s.getResponse(req, sn)
 		if err != nil {
-			return response, err
+			var nakErr *NakError
+			if req.MessageType() != dhcpv4.MessageTypeRequest || !errors.As(err, &nakErr) {
+				return response, err
+			}
+			s.log.Infof("Sending NAK to %s: %s", req.ClientHWAddr, nakErr)
+			resp, err = s.getNak(req, sn, nakErr)
+			if err != nil {
+				return response, err
+			}
+			response.Response = *resp
+			response.Request = req
+			return response, nil
 		}
 	default:
 		return response, fmt.Errorf("unknown dhcp packet type %s", req.MessageType())
 	}
-	lease.ServerId = sn.serverIPAddress
 	response.Response = *resp
 	response.Lease = lease
 	response.Request = req
+	response.needsProbe = req.MessageType() == dhcpv4.MessageTypeDiscover && lease.probePending
 
 	return response, nil
 }
 
-func (s *Server) GetLease(subnet SubnetAddrPrefix, mac string) *Lease {
-	sn, ok := s.subnets[subnet]
+func (s *Server) releaseLease(req Request) (*Lease, error) {
+	if isAddressZero(req.ServerIdentifier()) {
+		return nil, fmt.Errorf("release from %s without server identifier", req.ClientHWAddr)
+	}
+	if isAddressZero(req.ClientIPAddr) {
+		return nil, fmt.Errorf("release from %s without client address", req.ClientHWAddr)
+	}
+	sn := s.getSubnetForIp(req.ClientIPAddr)
+	if sn == nil {
+		return nil, fmt.Errorf("unknown subnet for released address %s", req.ClientIPAddr)
+	}
+	s.log.Infof("Releasing %s (%s)", req.ClientIPAddr, req.ClientHWAddr)
+	return sn.ReleaseLease(req.DHCPv4, req.ClientIPAddr)
+}
+
+func (s *Server) declineLease(req Request) (*Lease, error) {
+	if isAddressZero(req.ServerIdentifier()) {
+		return nil, fmt.Errorf("decline from %s without server identifier", req.ClientHWAddr)
+	}
+	ip := req.RequestedIPAddress()
+	if isAddressZero(ip) {
+		return nil, fmt.Errorf("decline from %s without requested address", req.ClientHWAddr)
+	}
+	sn := s.getSubnetForIp(ip)
+	if sn == nil {
+		return nil, fmt.Errorf("unknown subnet for declined address %s", ip)
+	}
+	s.log.Infof("Address %s declined by %s: %s", ip, req.ClientHWAddr, req.Message())
+	return sn.DeclineLease(req.DHCPv4, ip)
+}
+
+// FindLease returns copy of the DHCPv4 lease of the hardware address at the address, or nil if there is none.
+// Lease is looked up by the address, so the caller can't get lease of another client by its MAC alone.
+// Released and expired leases are skipped
+func (s *Server) FindLease(mac string, ip net.IP) *Lease {
+	sn := s.getSubnetForIp(ip)
+	if sn == nil {
+		return nil
+	}
+	sn.leaseCacheMutex.Lock()
+	defer sn.leaseCacheMutex.Unlock()
+	lease, ok := sn.leaseCache[ip.String()]
+	if !ok || lease.MAC != mac || lease.Released || lease.Declined || lease.IsExpired() {
+		return nil
+	}
+	l := *lease
+	return &l
+}
+
+// GetLease returns copy of lease by its key (MAC or client id, see Lease.Key), or nil if there is none
+func (s *Server) GetLease(subnet SubnetAddrPrefix, key string) *Lease {
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	var (
+		lease *Lease
+		ok    bool
+	)
+	if sn6, found := s.subnets6[subnet]; found {
+		sn6.leaseCacheMutex.Lock()
+		defer sn6.leaseCacheMutex.Unlock()
+		lease, ok = sn6.leaseCache[key]
+	} else if sn, found := s.subnets[subnet]; found {
+		sn.leaseCacheMutex.Lock()
+		defer sn.leaseCacheMutex.Unlock()
+		lease, ok = sn.leaseCache[key]
+	}
 	if !ok {
 		return nil
 	}
-	return sn.leaseCache[mac]
+	l := *lease
+	return &l
 }
 
 func (s *Server) getResponse(req Request, subnet *Subnet) (*dhcpv4.DHCPv4, *Lease, error) {
-	lease := subnet.GetLeaseForRequest(req.DHCPv4)
-	if lease == nil {
-		//TODO: return NAK
-		return nil, lease, fmt.Errorf("nil lease")
+	classes := s.matchClientClasses(req)
+	binding := LeaseBinding{
+		LeaseTime: subnet.grantedLeaseTime(req.DHCPv4, subnet.classLeaseTime(classes)),
+		RelayInfo: req.RelayInfo,
+	}
+	lease, err := subnet.GetLeaseForClassifiedRequest(req.DHCPv4, classes, binding)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	resp, err := dhcpv4.NewReplyFromRequest(req.DHCPv4)
@@ -269,29 +521,71 @@ func (s *Server) getResponse(req Request, subnet *Subnet) (*dhcpv4.DHCPv4, *Leas
 	}
 
 	resp.YourIPAddr = lease.IP
-	resp.ServerIPAddr = subnet.serverIPAddress
-	lease.ServerId = subnet.serverIPAddress
+	if isRenewal(req.DHCPv4) {
+		resp.ClientIPAddr = req.ClientIPAddr
+	}
+	resp.ServerIPAddr = subnet.nextServer(req)
+	params := applyClientClasses(*lease, subnet, classes)
+	if params.LeaseTime == 0 {
+		//host reservation without lease time
+		params.LeaseTime = subnet.LeaseTime
+	}
+	if !lease.Static || lease.LeaseTime == 0 {
+		//client may choose lease time unless it is set by host reservation
+		params.LeaseTime = subnet.grantedLeaseTime(req.DHCPv4, params.LeaseTime)
+	}
+
+	params.Options = s.evaluateOptions(req, params.Options)
+	err = s.setLeaseOptions(resp, &params)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.setBootParameters(req.DHCPv4, resp, &params)
+	s.setIPXEScript(req, resp, &params, subnet)
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Duration(params.LeaseTime) * time.Second))
+	s.setTimers(resp, &params, subnet)
+	filterRequestedOptions(req.DHCPv4, resp, params.Options)
+	s.setVendorOptions(req, resp, subnet, classes)
+
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRequest:
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case dhcpv4.MessageTypeDiscover:
+		if subnet.isRapidCommit(req.DHCPv4) {
+			s.log.Debugf("Rapid commit for %s", req.ClientHWAddr)
+			resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+			resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionRapidCommit, []byte{}))
+			break
+		}
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+	default:
+		s.log.Infof("Unknown request type: %s", req.MessageType().String())
+		return nil, nil, err
+	}
+
+	return resp, lease, err
+}
 
+// setLeaseOptions sets options and boot parameters from lease, except of address and lease time
+func (s *Server) setLeaseOptions(resp *dhcpv4.DHCPv4, lease *Lease) error {
 	for _, opt := range lease.Options {
-		var value dhcpv4.OptionValue
-		code := opt.ID
-		switch opt.Type {
-		case "string":
-			value = dhcpv4.String(opt.Value)
-		default:
-			s.log.Infof("unknown option type %s in subnet: %v", opt.Type, subnet)
-			return nil, nil, fmt.Errorf("unknown option type %s", opt.Type)
+		data, err := opt.Encode()
+		if err != nil {
+			s.log.Errorf(err, "skipping invalid option in subnet %s", lease.Subnet)
+			continue
 		}
-		resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.GenericOptionCode(code), Value: value})
-		if code == 66 {
+		code := opt.ID
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), data))
+		if code == dhcpv4.OptionTFTPServerName.Code() {
 			//iPXE wont boot if not set server ip addr to option 66 value
-			//resp.ServerIPAddr = net.ParseIP(value.String()) //TODO
+			if ip := net.ParseIP(opt.Value); ip != nil && ip.To4() != nil {
+				resp.ServerIPAddr = ip.To4()
+			}
 		}
 	}
 	resp.BootFileName = lease.BootFileName
 	resp.ServerHostName = lease.ServerHostName
 	resp.UpdateOption(dhcpv4.OptSubnetMask(net.IPMask(net.ParseIP(lease.NetMask).To4())))
-	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Duration(lease.LeaseTime) * time.Second))
 	resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.GenericOptionCode(3), Value: dhcpv4.IP(lease.Gateway)})
 	//resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.GenericOptionCode(28), Value: dhcpv4.IP{10, 12, 1, 255}}) //broadcast
 	dnsServers := make([]net.IP, 0)
@@ -300,18 +594,88 @@ func (s *Server) getResponse(req Request, subnet *Subnet) (*dhcpv4.DHCPv4, *Leas
 	}
 	resp.UpdateOption(dhcpv4.OptDNS(dnsServers...))
 	resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.GenericOptionCode(54), Value: dhcpv4.IP(lease.ServerId)})
-	//resp.UpdateOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: mirantisEntID, Data: []byte("fo\x11obar")}))
-	resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.GenericOptionCode(43), Value: &dhcpv4.OptionGeneric{Data: []byte("123")}})
+	return nil
+}
 
-	switch req.MessageType() {
-	case dhcpv4.MessageTypeRequest:
-		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
-	case dhcpv4.MessageTypeDiscover:
-		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
-	default:
-		s.log.Infof("Unknown request type: %s", req.MessageType().String())
-		return nil, nil, err
+// matchSubnet returns error if request doesn't match expression of the subnet, so it is ignored
+func (s *Server) matchSubnet(req Request, sn *Subnet) error {
+	ok, err := sn.Match.Match(req)
+	if err != nil {
+		return fmt.Errorf("subnet %s: %w", sn.Subnet, err)
+	}
+	if !ok {
+		return fmt.Errorf("request from %s does not match subnet %s expression", req.ClientHWAddr, sn.Subnet)
 	}
+	return nil
+}
 
-	return resp, lease, err
+// evaluateOptions returns copy of options with values of expression options computed for the request.
+// Options which expressions fail are skipped
+func (s *Server) evaluateOptions(req Request, options []Option) []Option {
+	result := make([]Option, 0, len(options))
+	for _, opt := range options {
+		if opt.Expression != nil {
+			value, err := opt.Expression.Value(req)
+			if err != nil {
+				s.log.Errorf(err, "skipping option %d for %s", opt.ID, req.ClientHWAddr)
+				continue
+			}
+			opt.Value = value
+			opt.Expression = nil
+		}
+		result = append(result, opt)
+	}
+	return result
+}
+
+func (s *Server) getInformResponse(req Request) (*dhcpv4.DHCPv4, error) {
+	if isAddressZero(req.ClientIPAddr) {
+		return nil, fmt.Errorf("inform from %s without client address", req.ClientHWAddr)
+	}
+	sn := s.getSubnetForIp(req.ClientIPAddr)
+	if sn == nil && !isAddressZero(req.GatewayIPAddr) {
+		sn = s.getSubnetForIp(req.GatewayIPAddr)
+	}
+	if sn == nil {
+		return nil, fmt.Errorf("unknown subnet for inform from %s", req.ClientIPAddr)
+	}
+	if err := s.matchSubnet(req, sn); err != nil {
+		return nil, err
+	}
+	lease := sn.GetInformLease(req.DHCPv4, req.ClientIPAddr)
+	lease.ServerId = sn.serverIPAddress
+	lease.Options = s.evaluateOptions(req, lease.Options)
+
+	resp, err := dhcpv4.NewReplyFromRequest(req.DHCPv4)
+	if err != nil {
+		return nil, err
+	}
+	resp.ClientIPAddr = req.ClientIPAddr
+	resp.ServerIPAddr = sn.nextServer(req)
+	err = s.setLeaseOptions(resp, lease)
+	if err != nil {
+		return nil, err
+	}
+	s.setBootParameters(req.DHCPv4, resp, lease)
+	s.setIPXEScript(req, resp, lease, sn)
+	filterRequestedOptions(req.DHCPv4, resp, lease.Options)
+	s.setVendorOptions(req, resp, sn, nil)
+	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	return resp, nil
+}
+
+func (s *Server) getNak(req Request, subnet *Subnet, nakErr *NakError) (*dhcpv4.DHCPv4, error) {
+	resp, err := dhcpv4.NewReplyFromRequest(req.DHCPv4,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeNak),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(subnet.serverIPAddress)),
+		dhcpv4.WithOption(dhcpv4.OptMessage(nakErr.Message)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !isAddressZero(req.GatewayIPAddr) {
+		//relay agent must broadcast NAK to the client
+		resp.SetBroadcast()
+	}
+	return resp, nil
 }
@@ -0,0 +1,360 @@
+package dhcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"net"
+	"os"
+	"time"
+)
+
+// defaultServerDUID returns DUID-LL of the first interface with ethernet address, or DUID-EN otherwise
+func defaultServerDUID() dhcpv6.Duid {
+	ifs, err := net.Interfaces()
+	if err == nil {
+		for _, i := range ifs {
+			if i.Flags&net.FlagLoopback == 0 && len(i.HardwareAddr) == 6 {
+				return dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: i.HardwareAddr}
+			}
+		}
+	}
+	hostname, _ := os.Hostname()
+	return dhcpv6.Duid{Type: dhcpv6.DUID_EN, EnterpriseNumber: mirantisEntID, EnterpriseIdentifier: []byte(hostname)}
+}
+
+func newSubnet6(subnet Subnet) Subnet6 {
+	return Subnet6{
+		Subnet:      subnet.Subnet,
+		RangeFrom:   subnet.RangeFrom,
+		RangeTo:     subnet.RangeTo,
+		DNS:         subnet.DNS,
+		LeaseTime:   subnet.LeaseTime,
+		RapidCommit: subnet.RapidCommit,
+		PDPrefix:    subnet.PDPrefix,
+		PDLength:    subnet.PDLength,
+	}
+}
+
+func (s *Server) addSubnet6(subnet Subnet6) error {
+	err := InitializeSubnet6(&subnet)
+	if err != nil {
+		return err
+	}
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	for _, sn := range s.subnets6 {
+		if sn.Subnet == subnet.Subnet {
+			return fmt.Errorf("overlapping subnets: %s, %s", sn.Subnet, subnet.Subnet)
+		}
+	}
+	var ctx context.Context
+	ctx, subnet.stopReaper = context.WithCancel(s.context)
+	s.subnets6[subnet.Subnet] = &subnet
+	go s.runLeaseReaper(ctx, subnet.Subnet, &subnet)
+	return nil
+}
+
+func (s *Server) getSubnet6ForIp(ip net.IP) *Subnet6 {
+	s.subnetMutex.Lock()
+	defer s.subnetMutex.Unlock()
+	for _, sn := range s.subnets6 {
+		if sn.Contains(ip) {
+			return sn
+		}
+	}
+	return nil
+}
+
+// relayLinkAddr returns link-address of relay agent closest to the client
+func relayLinkAddr(msg dhcpv6.DHCPv6) net.IP {
+	var link net.IP
+	for msg != nil && msg.IsRelay() {
+		relay := msg.(*dhcpv6.RelayMessage)
+		if !relay.LinkAddr.IsUnspecified() {
+			link = relay.LinkAddr
+		}
+		msg = relay.Options.RelayMessage()
+	}
+	return link
+}
+
+// clientPeerAddr returns address of the client: peer-address of relay agent closest to the client
+// or source address of direct request
+func clientPeerAddr(req Request6) net.IP {
+	var msg dhcpv6.DHCPv6 = req.DHCPv6
+	var peer net.IP
+	for msg != nil && msg.IsRelay() {
+		relay := msg.(*dhcpv6.RelayMessage)
+		peer = relay.PeerAddr
+		msg = relay.Options.RelayMessage()
+	}
+	if peer != nil {
+		return peer
+	}
+	if addr, ok := req.Src.(*net.UDPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+func (s *Server) getSubnet6(req Request6) *Subnet6 {
+	if req.IsRelay() {
+		link := relayLinkAddr(req.DHCPv6)
+		s.log.Debugf("Handling relayed request with link address %s", link)
+		if link == nil {
+			return nil
+		}
+		return s.getSubnet6ForIp(link)
+	}
+	for _, ip := range s.localIpAddresses[req.InterfaceName] {
+		if sn := s.getSubnet6ForIp(ip); sn != nil {
+			return sn
+		}
+	}
+	return nil
+}
+
+// GetResponse6 handles DHCPv6 message. Response contains reply (wrapped in relay-repl for relayed
+// requests) and leases to be saved before reply is sent.
+func (s *Server) GetResponse6(req Request6) (Response6, error) {
+	response := Response6{Request: req}
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		return response, err
+	}
+	clientID := msg.Options.ClientID()
+	if clientID == nil && msg.Type() != dhcpv6.MessageTypeInformationRequest {
+		return response, fmt.Errorf("%s without client id", msg.Type())
+	}
+	serverID := msg.Options.ServerID()
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRebind:
+		if serverID != nil {
+			return response, fmt.Errorf("%s with server id", msg.Type())
+		}
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRelease:
+		if serverID == nil || !serverID.Equal(s.duid) {
+			return response, fmt.Errorf("%s for unknown server id: %s", msg.Type(), serverID)
+		}
+	case dhcpv6.MessageTypeInformationRequest:
+		if serverID != nil && !serverID.Equal(s.duid) {
+			return response, fmt.Errorf("%s for unknown server id: %s", msg.Type(), serverID)
+		}
+	default:
+		return response, fmt.Errorf("unsupported dhcpv6 message type %s", msg.Type())
+	}
+
+	sn := s.getSubnet6(req)
+	if sn == nil {
+		return response, fmt.Errorf("unknown subnet %s %s", req.Src, req.InterfaceName)
+	}
+
+	var reply *dhcpv6.Message
+	rapidCommit := msg.Type() == dhcpv6.MessageTypeSolicit &&
+		sn.RapidCommit && msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil
+	if msg.Type() == dhcpv6.MessageTypeSolicit && !rapidCommit {
+		reply, err = dhcpv6.NewAdvertiseFromSolicit(msg)
+	} else if clientID == nil {
+		//information-request may omit client id (RFC 8415 18.2.6), which NewReplyFromMessage requires
+		reply = &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply, TransactionID: msg.TransactionID}
+	} else {
+		reply, err = dhcpv6.NewReplyFromMessage(msg)
+	}
+	if err != nil {
+		return response, err
+	}
+	reply.AddOption(dhcpv6.OptServerID(s.duid))
+
+	if clientID != nil {
+		response.Leases = s.handleIANA(sn, req, msg, reply, clientID, rapidCommit)
+		response.Leases = append(response.Leases, s.handleIAPD(sn, req, msg, reply, clientID, rapidCommit)...)
+	}
+	if msg.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		var dns []net.IP
+		for _, d := range sn.DNS {
+			if ip := net.ParseIP(d); ip != nil && ip.To4() == nil {
+				dns = append(dns, ip)
+			}
+		}
+		if len(dns) > 0 {
+			reply.AddOption(dhcpv6.OptDNS(dns...))
+		}
+	}
+
+	if relay, ok := req.DHCPv6.(*dhcpv6.RelayMessage); ok {
+		response.Response, err = dhcpv6.NewRelayReplFromRelayForw(relay, reply)
+		return response, err
+	}
+	response.Response = reply
+	return response, nil
+}
+
+// newIARequest returns request of client's identity association with allocation flags set by message type.
+// False is returned for message types which don't bind addresses.
+func newIARequest(req Request6, msg *dhcpv6.Message, clientID *dhcpv6.Duid, iaid [4]byte, rapidCommit bool) (iaRequest, bool) {
+	ia := iaRequest{
+		DUID:        formatHex(clientID.ToBytes()),
+		IAID:        binary.BigEndian.Uint32(iaid[:]),
+		PeerAddress: clientPeerAddr(req),
+	}
+	if hwaddr, err := dhcpv6.ExtractMAC(req.DHCPv6); err == nil {
+		ia.MAC = hwaddr.String()
+	}
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit:
+		ia.Allocate = true
+		ia.Commit = rapidCommit
+	case dhcpv6.MessageTypeRequest:
+		ia.Allocate = true
+		ia.Commit = true
+	case dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+		ia.Commit = true
+	case dhcpv6.MessageTypeRelease:
+	default:
+		return ia, false
+	}
+	return ia, true
+}
+
+// handleIANA adds IA_NA options to reply and returns changed leases
+func (s *Server) handleIANA(sn *Subnet6, req Request6, msg *dhcpv6.Message, reply *dhcpv6.Message,
+	clientID *dhcpv6.Duid, rapidCommit bool) []*Lease {
+	var leases []*Lease
+	for _, opt := range msg.Options.IANA() {
+		ia, ok := newIARequest(req, msg, clientID, opt.IaId, rapidCommit)
+		if !ok {
+			continue
+		}
+		duid := ia.DUID
+		if addr := opt.Options.OneAddress(); addr != nil {
+			ia.Hint = addr.IPv6Addr
+		}
+		if msg.Type() == dhcpv6.MessageTypeRelease {
+			status := iana.StatusSuccess
+			if ia.Hint == nil {
+				status = iana.StatusNoBinding
+			} else if lease, err := sn.ReleaseLease(ia.DUID, ia.IAID, ia.Hint); err != nil {
+				s.log.Infof("Release of %s by %s: %s", ia.Hint, duid, err)
+				status = iana.StatusNoBinding
+			} else {
+				leases = append(leases, lease)
+			}
+			reply.AddOption(iaStatus(opt.IaId, status))
+			continue
+		}
+		lease, err := sn.GetLeaseForIA(ia)
+		var nakErr *NakError
+		switch {
+		case errors.Is(err, errNoBinding):
+			reply.AddOption(iaStatus(opt.IaId, iana.StatusNoBinding))
+			continue
+		case errors.As(err, &nakErr):
+			s.log.Infof("No address for %s: %s", duid, nakErr)
+			reply.AddOption(iaStatus(opt.IaId, iana.StatusNoAddrsAvail))
+			continue
+		case err != nil:
+			s.log.Errorf(err, "failed to get lease for %s", duid)
+			continue
+		}
+		lifetime := time.Duration(lease.LeaseTime) * time.Second
+		reply.AddOption(&dhcpv6.OptIANA{
+			IaId: opt.IaId,
+			T1:   lifetime / 2,
+			T2:   lifetime * 4 / 5,
+			Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{&dhcpv6.OptIAAddress{
+				IPv6Addr:          lease.IP,
+				PreferredLifetime: lifetime,
+				ValidLifetime:     lifetime,
+			}}},
+		})
+		leases = append(leases, lease)
+	}
+	if msg.Type() == dhcpv6.MessageTypeRelease {
+		reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess, StatusMessage: "released"})
+	}
+	return leases
+}
+
+// handleIAPD adds IA_PD options to reply and returns changed leases of delegated prefixes
+func (s *Server) handleIAPD(sn *Subnet6, req Request6, msg *dhcpv6.Message, reply *dhcpv6.Message,
+	clientID *dhcpv6.Duid, rapidCommit bool) []*Lease {
+	var leases []*Lease
+	for _, opt := range msg.Options.IAPD() {
+		ia, ok := newIARequest(req, msg, clientID, opt.IaId, rapidCommit)
+		if !ok {
+			continue
+		}
+		if p := opt.Options.Prefixes(); len(p) > 0 && p[0].Prefix != nil {
+			ia.HintPrefix = p[0].Prefix
+		}
+		if msg.Type() == dhcpv6.MessageTypeRelease {
+			status := iana.StatusSuccess
+			if ia.HintPrefix == nil {
+				status = iana.StatusNoBinding
+			} else if lease, err := sn.ReleasePrefix(ia.DUID, ia.IAID, ia.HintPrefix); err != nil {
+				s.log.Infof("Release of %s by %s: %s", ia.HintPrefix, ia.DUID, err)
+				status = iana.StatusNoBinding
+			} else {
+				leases = append(leases, lease)
+			}
+			reply.AddOption(iaPDStatus(opt.IaId, status))
+			continue
+		}
+		lease, err := sn.GetPrefixForIA(ia)
+		var nakErr *NakError
+		switch {
+		case errors.Is(err, errNoBinding):
+			status := iana.StatusNoBinding
+			if ia.Allocate {
+				status = iana.StatusNoPrefixAvail
+			}
+			reply.AddOption(iaPDStatus(opt.IaId, status))
+			continue
+		case errors.As(err, &nakErr):
+			s.log.Infof("No prefix for %s: %s", ia.DUID, nakErr)
+			reply.AddOption(iaPDStatus(opt.IaId, iana.StatusNoPrefixAvail))
+			continue
+		case err != nil:
+			s.log.Errorf(err, "failed to get prefix for %s", ia.DUID)
+			continue
+		}
+		lifetime := time.Duration(lease.LeaseTime) * time.Second
+		reply.AddOption(&dhcpv6.OptIAPD{
+			IaId: opt.IaId,
+			T1:   lifetime / 2,
+			T2:   lifetime * 4 / 5,
+			Options: dhcpv6.PDOptions{Options: dhcpv6.Options{&dhcpv6.OptIAPrefix{
+				PreferredLifetime: lifetime,
+				ValidLifetime:     lifetime,
+				Prefix: &net.IPNet{
+					IP:   lease.IP,
+					Mask: net.CIDRMask(lease.PrefixLength, 8*net.IPv6len),
+				},
+			}}},
+		})
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+func iaPDStatus(iaid [4]byte, status iana.StatusCode) *dhcpv6.OptIAPD {
+	return &dhcpv6.OptIAPD{
+		IaId: iaid,
+		Options: dhcpv6.PDOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptStatusCode{StatusCode: status, StatusMessage: status.String()},
+		}},
+	}
+}
+
+func iaStatus(iaid [4]byte, status iana.StatusCode) *dhcpv6.OptIANA {
+	return &dhcpv6.OptIANA{
+		IaId: iaid,
+		Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptStatusCode{StatusCode: status, StatusMessage: status.String()},
+		}},
+	}
+}
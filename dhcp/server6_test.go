@@ -0,0 +1,307 @@
+package dhcp
+
+import (
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type MockSocket6 struct {
+	requestChan  chan Request6
+	responseChan chan dhcpv6.DHCPv6
+}
+
+func (s *MockSocket6) NextRequest() (*Request6, error) {
+	req := <-s.requestChan
+	return &req, nil
+}
+
+func (s *MockSocket6) SendTo(resp dhcpv6.DHCPv6, addr net.Addr) error {
+	s.responseChan <- resp
+	return nil
+}
+
+func (s *MockSocket6) Close() {
+}
+
+type mockSocket6Factory struct {
+	mockSocket MockSocket6
+}
+
+func (f *mockSocket6Factory) Factory(_ string, _ string, _ RLogger) (Socket6, error) {
+	return &f.mockSocket, nil
+}
+
+func newTestServer6(t *testing.T, saved *[]Response) (*Server, *mockSocket6Factory) {
+	socketFactory := &mockSocket6Factory{mockSocket: MockSocket6{
+		requestChan:  make(chan Request6, 16),
+		responseChan: make(chan dhcpv6.DHCPv6, 16),
+	}}
+	m, err := NewServer(ServerConfig{
+		CallbackSaveLeases: func(resps []Response) error {
+			*saved = append(*saved, resps...)
+			return nil
+		},
+		Socket6Factory:       socketFactory.Factory,
+		LocalAddressesGetter: mockGetLocalAddresses,
+		Logger:               &GenericLogger{},
+		DUID:                 &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}},
+	})
+	require.NoError(t, err)
+	err = m.AddListen(Listen{Name: "v6", Interface: "br1", Protocol: ProtocolDHCPv6})
+	require.NoError(t, err)
+	err = m.AddSubnet(Subnet{
+		Subnet:    "2001:db8:3::/64",
+		RangeFrom: "2001:db8:3::100",
+		RangeTo:   "2001:db8:3::1ff",
+		DNS:       []string{"2001:db8::53", "1.1.1.1"},
+		LeaseTime: 3600,
+		PDPrefix:  "2001:db8:100::/40",
+		PDLength:  56,
+	})
+	require.NoError(t, err)
+	err = m.AddSubnet(Subnet{
+		Subnet:    "2001:db8:7::/64",
+		RangeFrom: "2001:db8:7::10",
+		RangeTo:   "2001:db8:7::11",
+	})
+	require.NoError(t, err)
+	return m, socketFactory
+}
+
+func exchange6(f *mockSocket6Factory, msg dhcpv6.DHCPv6) dhcpv6.DHCPv6 {
+	f.mockSocket.requestChan <- Request6{
+		DHCPv6:        msg,
+		InterfaceName: "br1",
+		socket:        &f.mockSocket,
+	}
+	return <-f.mockSocket.responseChan
+}
+
+func TestServer6(t *testing.T) {
+	var saved []Response
+	m, f := newTestServer6(t, &saved)
+	defer m.Close()
+
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	require.NoError(t, err)
+	resp := exchange6(f, solicit)
+	adv := resp.(*dhcpv6.Message)
+	require.Equal(t, dhcpv6.MessageTypeAdvertise, adv.Type())
+	require.True(t, adv.Options.ServerID().Equal(m.duid))
+	require.Equal(t, []net.IP{net.ParseIP("2001:db8::53")}, adv.Options.DNS())
+	addr := adv.Options.OneIANA().Options.OneAddress().IPv6Addr
+	require.Equal(t, "2001:db8:3::100", addr.String())
+	require.Len(t, saved, 1)
+	require.False(t, saved[0].Lease.AckSent)
+
+	req, err := dhcpv6.NewRequestFromAdvertise(adv)
+	require.NoError(t, err)
+	reply := exchange6(f, req).(*dhcpv6.Message)
+	require.Equal(t, dhcpv6.MessageTypeReply, reply.Type())
+	require.Equal(t, addr, reply.Options.OneIANA().Options.OneAddress().IPv6Addr)
+	require.Len(t, saved, 2)
+	require.True(t, saved[1].Lease.AckSent)
+	key := saved[1].Lease.Key()
+	require.NotNil(t, m.GetLease("2001:db8:3::/64", key))
+
+	//renew with unknown IAID gets NoBinding
+	renew, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	renew.MessageType = dhcpv6.MessageTypeRenew
+	renew.AddOption(dhcpv6.OptClientID(*solicit.Options.ClientID()))
+	renew.AddOption(dhcpv6.OptServerID(m.duid))
+	renew.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{9, 9, 9, 9}})
+	reply = exchange6(f, renew).(*dhcpv6.Message)
+	require.Equal(t, iana.StatusNoBinding, reply.Options.OneIANA().Options.Status().StatusCode)
+
+	release, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	release.MessageType = dhcpv6.MessageTypeRelease
+	release.AddOption(dhcpv6.OptClientID(*solicit.Options.ClientID()))
+	release.AddOption(dhcpv6.OptServerID(m.duid))
+	release.AddOption(req.Options.OneIANA())
+	reply = exchange6(f, release).(*dhcpv6.Message)
+	require.Equal(t, iana.StatusSuccess, reply.Options.Status().StatusCode)
+	require.Len(t, saved, 3)
+	require.True(t, saved[2].Lease.Released)
+
+	//request for another server is ignored
+	req.UpdateOption(dhcpv6.OptServerID(dhcpv6.Duid{Type: dhcpv6.DUID_EN, EnterpriseNumber: 1}))
+	_, err = m.GetResponse6(Request6{DHCPv6: req, InterfaceName: "br1"})
+	require.Error(t, err)
+}
+
+func TestServer6_Relay(t *testing.T) {
+	var saved []Response
+	m, f := newTestServer6(t, &saved)
+	defer m.Close()
+
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{1, 2, 3, 4, 5, 7})
+	require.NoError(t, err)
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:7::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relayed.AddOption(dhcpv6.OptInterfaceID([]byte("eth7")))
+
+	resp := exchange6(f, relayed)
+	relayRepl := resp.(*dhcpv6.RelayMessage)
+	require.Equal(t, dhcpv6.MessageTypeRelayReply, relayRepl.Type())
+	require.Equal(t, []byte("eth7"), relayRepl.Options.InterfaceID())
+	adv, err := relayRepl.GetInnerMessage()
+	require.NoError(t, err)
+	require.Equal(t, dhcpv6.MessageTypeAdvertise, adv.Type())
+	require.Equal(t, "2001:db8:7::10", adv.Options.OneIANA().Options.OneAddress().IPv6Addr.String())
+	require.Equal(t, "01:02:03:04:05:07", saved[0].Lease.MAC)
+}
+
+func TestServer6_InformationRequest(t *testing.T) {
+	var saved []Response
+	m, f := newTestServer6(t, &saved)
+	defer m.Close()
+
+	//client id is optional in information-request
+	inform, err := dhcpv6.NewMessage(dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer))
+	require.NoError(t, err)
+	inform.MessageType = dhcpv6.MessageTypeInformationRequest
+	reply := exchange6(f, inform).(*dhcpv6.Message)
+	require.Equal(t, dhcpv6.MessageTypeReply, reply.Type())
+	require.Equal(t, inform.TransactionID, reply.TransactionID)
+	require.True(t, reply.Options.ServerID().Equal(m.duid))
+	require.Nil(t, reply.Options.ClientID())
+	require.Equal(t, []net.IP{net.ParseIP("2001:db8::53")}, reply.Options.DNS())
+	require.Empty(t, saved)
+
+	inform.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{1, 2, 3, 4, 5, 8}}))
+	reply = exchange6(f, inform).(*dhcpv6.Message)
+	require.Equal(t, dhcpv6.MessageTypeReply, reply.Type())
+	require.NotNil(t, reply.Options.ClientID())
+	require.Empty(t, saved)
+}
+
+func TestServer6_PrefixDelegation(t *testing.T) {
+	var saved []Response
+	m, f := newTestServer6(t, &saved)
+	defer m.Close()
+
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{1, 2, 3, 4, 5, 8})
+	require.NoError(t, err)
+	solicit.AddOption(&dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 7}})
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:3::1"), net.ParseIP("2001:db8:3::8"))
+	require.NoError(t, err)
+
+	relayRepl := exchange6(f, relayed).(*dhcpv6.RelayMessage)
+	adv, err := relayRepl.GetInnerMessage()
+	require.NoError(t, err)
+	iapd := adv.Options.OneIAPD()
+	require.NotNil(t, iapd)
+	require.Equal(t, "2001:db8:100::/56", iapd.Options.Prefixes()[0].Prefix.String())
+	require.Equal(t, time.Hour, iapd.Options.Prefixes()[0].ValidLifetime)
+	require.Len(t, saved, 2)
+	pd := saved[1].Lease
+	require.Equal(t, 56, pd.PrefixLength)
+	require.Equal(t, uint32(7), pd.IAID)
+	require.Equal(t, "2001:db8:3::8", pd.PeerAddress.String())
+
+	//sticky
+	relayRepl = exchange6(f, relayed).(*dhcpv6.RelayMessage)
+	adv, err = relayRepl.GetInnerMessage()
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:100::/56", adv.Options.OneIAPD().Options.Prefixes()[0].Prefix.String())
+
+	//subnet without delegation pool
+	solicit.UpdateOption(dhcpv6.OptClientID(dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{1, 2, 3, 4, 5, 9}}))
+	relayed, err = dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:7::1"), net.ParseIP("2001:db8:7::9"))
+	require.NoError(t, err)
+	relayRepl = exchange6(f, relayed).(*dhcpv6.RelayMessage)
+	adv, err = relayRepl.GetInnerMessage()
+	require.NoError(t, err)
+	require.Equal(t, iana.StatusNoPrefixAvail, adv.Options.OneIAPD().Options.Status().StatusCode)
+}
+
+func TestSubnet6_GetPrefixForIA(t *testing.T) {
+	s := &Subnet6{Subnet: "2001:db8::/64", RangeFrom: "2001:db8::1", RangeTo: "2001:db8::2",
+		PDPrefix: "2001:db8:100::/55", PDLength: 56}
+	require.NoError(t, InitializeSubnet6(s))
+	_, reserved, _ := net.ParseCIDR("2001:db8:200::/48")
+	s.AddHost(Host{DUID: "00:09", Prefix: reserved})
+
+	_, err := s.GetPrefixForIA(iaRequest{DUID: "00:01", IAID: 1})
+	require.ErrorIs(t, err, errNoBinding)
+	_, hint, _ := net.ParseCIDR("2001:db8:100:100::/56")
+	p1, err := s.GetPrefixForIA(iaRequest{DUID: "00:01", IAID: 1, Allocate: true, HintPrefix: hint})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:100:100::/56", p1.addrKey())
+	p2, err := s.GetPrefixForIA(iaRequest{DUID: "00:02", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:100::/56", p2.addrKey())
+	_, err = s.GetPrefixForIA(iaRequest{DUID: "00:03", IAID: 1, Allocate: true})
+	var nakErr *NakError
+	require.ErrorAs(t, err, &nakErr)
+
+	//sticky, address and prefix bindings of the same IAID are independent
+	p, err := s.GetPrefixForIA(iaRequest{DUID: "00:01", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, p1.addrKey(), p.addrKey())
+	l, err := s.GetLeaseForIA(iaRequest{DUID: "00:01", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::1", l.IP.String())
+
+	host, err := s.GetPrefixForIA(iaRequest{DUID: "00:09", IAID: 3})
+	require.NoError(t, err)
+	require.True(t, host.Static)
+	require.Equal(t, "2001:db8:200::/48", host.addrKey())
+
+	_, err = s.ReleasePrefix("00:01", 1, reserved)
+	require.Error(t, err)
+	released, err := s.ReleasePrefix("00:01", 1, hint)
+	require.NoError(t, err)
+	require.True(t, released.Released)
+	p3, err := s.GetPrefixForIA(iaRequest{DUID: "00:03", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:100:100::/56", p3.addrKey())
+
+	require.Error(t, InitializeSubnet6(&Subnet6{Subnet: "2001:db8::/64", RangeFrom: "2001:db8::1",
+		RangeTo: "2001:db8::2", PDPrefix: "2001:db8:100::/56", PDLength: 48}))
+}
+
+func TestSubnet6_GetLeaseForIA(t *testing.T) {
+	s := &Subnet6{Subnet: "2001:db8::/64", RangeFrom: "2001:db8::1", RangeTo: "2001:db8::2"}
+	require.NoError(t, InitializeSubnet6(s))
+	s.AddHost(Host{DUID: "00:03:00:01:02:00:00:00:00:09", IP: net.ParseIP("2001:db8::99")})
+
+	_, err := s.GetLeaseForIA(iaRequest{DUID: "00:01", IAID: 1})
+	require.ErrorIs(t, err, errNoBinding)
+	l1, err := s.GetLeaseForIA(iaRequest{DUID: "00:01", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::1", l1.IP.String())
+	l2, err := s.GetLeaseForIA(iaRequest{DUID: "00:01", IAID: 2, Allocate: true, Hint: net.ParseIP("2001:db8::2")})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::2", l2.IP.String())
+	_, err = s.GetLeaseForIA(iaRequest{DUID: "00:02", IAID: 1, Allocate: true})
+	require.Error(t, err)
+
+	//sticky
+	l, err := s.GetLeaseForIA(iaRequest{DUID: "00:01", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, l1.IP, l.IP)
+
+	host, err := s.GetLeaseForIA(iaRequest{DUID: "00:03:00:01:02:00:00:00:00:09", IAID: 5})
+	require.NoError(t, err)
+	require.True(t, host.Static)
+	require.Equal(t, "2001:db8::99", host.IP.String())
+	require.Equal(t, uint32(5), host.IAID)
+
+	released, err := s.ReleaseLease("00:01", 1, l1.IP)
+	require.NoError(t, err)
+	require.True(t, released.Released)
+	l3, err := s.GetLeaseForIA(iaRequest{DUID: "00:02", IAID: 1, Allocate: true})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::1", l3.IP.String())
+}
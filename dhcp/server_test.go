@@ -1,12 +1,15 @@
 package dhcp
 
 import (
+	"bytes"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/stretchr/testify/require"
 	"log"
 	"net"
+	"os"
 	"testing"
+	"time"
 )
 
 func mockSaveLeasesCallback(resps []Response) error {
@@ -19,6 +22,7 @@ type MockSocket struct {
 	requestChan    chan Request
 	responseChan   chan dhcpv4.DHCPv4
 	currentRequest int
+	sentTo         *net.UDPAddr //destination of the last response sent by SendTo, nil for broadcast
 }
 
 func (s *MockSocket) NextRequest() (*Request, error) {
@@ -32,10 +36,23 @@ func (s *MockSocket) SendResponse(req Request, resp dhcpv4.DHCPv4) error {
 }
 
 func (s *MockSocket) SendBroadcast(req Request, resp dhcpv4.DHCPv4) error {
+	s.sentTo = nil
 	s.responseChan <- resp
 	return nil
 }
 
+func (s *MockSocket) SendTo(req Request, resp dhcpv4.DHCPv4, addr *net.UDPAddr) error {
+	s.sentTo = addr
+	s.responseChan <- resp
+	return nil
+}
+
+func (s *MockSocket) SendRelayed(req *dhcpv4.DHCPv4, addr *net.UDPAddr) error {
+	s.sentTo = addr
+	s.responseChan <- *req
+	return nil
+}
+
 func (s *MockSocket) Close() {
 	return
 }
@@ -53,6 +70,7 @@ func mockGetLocalAddresses() (LocalIPAddresses, error) {
 		},
 		"br1": {
 			net.ParseIP("10.3.1.1"),
+			net.ParseIP("2001:db8:3::1"),
 		},
 	}
 	return la, nil
@@ -73,6 +91,28 @@ func (f *mockSocketFactory) Factory(listenAddress string, interfaceName string,
 	return &f.mockSocket, nil
 }
 
+// newTestServer returns server with mock sockets and local addresses, listening on listens.
+// Callback saving leases does nothing unless set in config
+func newTestServer(t *testing.T, config ServerConfig, listens ...Listen) (*Server, *mockSocketFactory) {
+	socketFactory := &mockSocketFactory{
+		requestChan:  make(chan Request, 16),
+		responseChan: make(chan dhcpv4.DHCPv4, 16),
+	}
+	if config.CallbackSaveLeases == nil {
+		config.CallbackSaveLeases = mockSaveLeasesCallback
+	}
+	config.SocketFactory = socketFactory.Factory
+	config.LocalAddressesGetter = mockGetLocalAddresses
+	config.Logger = &GenericLogger{}
+	m, err := NewServer(config)
+	require.NoError(t, err)
+	t.Cleanup(m.Close)
+	for _, listen := range listens {
+		require.NoError(t, m.AddListen(listen))
+	}
+	return m, socketFactory
+}
+
 func TestNewServer(t *testing.T) {
 	requestChan := make(chan Request, 16)
 	responseChan := make(chan dhcpv4.DHCPv4, 16)
@@ -136,3 +176,1186 @@ func TestNewServer(t *testing.T) {
 	assertEqual(t, resp.YourIPAddr.String(), "10.3.1.10")
 	m.Close()
 }
+
+func TestServer_Nak(t *testing.T) {
+	savedLeases := 0
+
+	m, socketFactory := newTestServer(t, ServerConfig{
+		CallbackSaveLeases: func(resps []Response) error {
+			savedLeases += len(resps)
+			return nil
+		},
+	}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:    "10.3.1.0/24",
+		RangeFrom: "10.3.1.10",
+		RangeTo:   "10.3.1.13",
+		Gateway:   "10.3.1.254",
+		LeaseTime: 3600,
+	})
+	require.NoError(t, err)
+
+	dr := &dhcpv4.DHCPv4{
+		OpCode:        dhcpv4.OpcodeBootRequest,
+		HWType:        iana.HWTypeEthernet,
+		TransactionID: dhcpv4.TransactionID{1, 2, 3, 4},
+		ClientHWAddr:  net.HardwareAddr{1, 2, 3, 4, 5, 6},
+	}
+	dr.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+	dr.UpdateOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.10")))
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	require.True(t, isAddressZero(resp.YourIPAddr))
+	require.Equal(t, "10.3.1.1", resp.ServerIdentifier().String())
+	require.NotEmpty(t, resp.Message())
+	require.Equal(t, 0, savedLeases)
+}
+
+func TestServer_Inform(t *testing.T) {
+	savedLeases := 0
+
+	m, socketFactory := newTestServer(t, ServerConfig{
+		CallbackSaveLeases: func(resps []Response) error {
+			savedLeases += len(resps)
+			return nil
+		},
+	}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:    "10.3.1.0/24",
+		RangeFrom: "10.3.1.10",
+		RangeTo:   "10.3.1.13",
+		Gateway:   "10.3.1.254",
+		DNS:       []string{"1.1.1.1"},
+		LeaseTime: 3600,
+	})
+	require.NoError(t, err)
+
+	dr := &dhcpv4.DHCPv4{
+		OpCode:        dhcpv4.OpcodeBootRequest,
+		HWType:        iana.HWTypeEthernet,
+		TransactionID: dhcpv4.TransactionID{1, 2, 3, 4},
+		ClientHWAddr:  net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		ClientIPAddr:  net.ParseIP("10.3.1.50"),
+	}
+	dr.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeInform))
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	require.True(t, isAddressZero(resp.YourIPAddr))
+	require.Equal(t, "10.3.1.50", resp.ClientIPAddr.String())
+	require.Nil(t, resp.GetOneOption(dhcpv4.OptionIPAddressLeaseTime))
+	require.Equal(t, []net.IP{net.ParseIP("1.1.1.1").To4()}, resp.DNS())
+	require.Nil(t, m.GetLease("10.3.1.0/24", dr.ClientHWAddr.String()))
+	require.Equal(t, 0, savedLeases)
+}
+
+func TestServer_RelayAgentInfo(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	for _, sn := range []Subnet{
+		{Subnet: "10.5.0.0/24", RangeFrom: "10.5.0.10", RangeTo: "10.5.0.20", Gateway: "10.5.0.1"},
+		{Subnet: "10.6.0.0/24", RangeFrom: "10.6.0.10", RangeTo: "10.6.0.20", Gateway: "10.6.0.1"},
+		{Subnet: "10.3.1.0/24", RangeFrom: "10.3.1.10", RangeTo: "10.3.1.20", Gateway: "10.3.1.1"},
+	} {
+		require.NoError(t, m.AddSubnet(sn))
+	}
+
+	dr := &dhcpv4.DHCPv4{
+		OpCode:        dhcpv4.OpcodeBootRequest,
+		HWType:        iana.HWTypeEthernet,
+		TransactionID: dhcpv4.TransactionID{1, 2, 3, 4},
+		ClientHWAddr:  net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		GatewayIPAddr: net.ParseIP("10.5.0.1"),
+	}
+	dr.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover))
+	dr.UpdateOption(dhcpv4.OptRelayAgentInfo(
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth1/0/12")),
+		dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte{0, 1, 2}),
+		dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 6, 0, 0}),
+	))
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, "10.6.0.10", resp.YourIPAddr.String())
+	require.Equal(t, dr.GetOneOption(dhcpv4.OptionRelayAgentInformation), resp.GetOneOption(dhcpv4.OptionRelayAgentInformation))
+	lease := m.GetLease("10.6.0.0/24", dr.ClientHWAddr.String())
+	require.Equal(t, "eth1/0/12", lease.CircuitID)
+	require.Equal(t, "00:01:02", lease.RemoteID)
+
+	//option 82 of directly attached client is ignored
+	dr.ClientHWAddr = net.HardwareAddr{1, 2, 3, 4, 5, 7}
+	dr.GatewayIPAddr = net.IPv4zero
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, "10.3.1.10", resp.YourIPAddr.String())
+	require.Nil(t, resp.RelayAgentInfo())
+	lease = m.GetLease("10.3.1.0/24", dr.ClientHWAddr.String())
+	require.Empty(t, lease.CircuitID)
+}
+
+func TestServer_RapidCommit(t *testing.T) {
+	var saved []Response
+
+	m, socketFactory := newTestServer(t, ServerConfig{
+		CallbackSaveLeases: func(resps []Response) error {
+			saved = append(saved, resps...)
+			return nil
+		},
+	}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:      "10.3.1.0/24",
+		RangeFrom:   "10.3.1.10",
+		RangeTo:     "10.3.1.13",
+		Gateway:     "10.3.1.254",
+		LeaseTime:   3600,
+		RapidCommit: true,
+	})
+	require.NoError(t, err)
+
+	dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionRapidCommit, []byte{})))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	require.True(t, resp.Options.Has(dhcpv4.OptionRapidCommit))
+	require.Equal(t, "10.3.1.10", resp.YourIPAddr.String())
+	require.Len(t, saved, 1)
+	require.True(t, saved[0].Lease.AckSent)
+
+	//discover without rapid commit option gets regular offer
+	dr, err = dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 7})
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+	require.False(t, resp.Options.Has(dhcpv4.OptionRapidCommit))
+}
+
+func TestServer_BootProfiles(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:       "10.3.1.0/24",
+		RangeFrom:    "10.3.1.10",
+		RangeTo:      "10.3.1.20",
+		Gateway:      "10.3.1.254",
+		LeaseTime:    3600,
+		BootFileName: "default.kpxe",
+		BootProfiles: []BootProfile{
+			{Arch: []iana.Arch{iana.INTEL_X86PC}, BootFileName: "undionly.kpxe", NextServer: net.IP{10, 3, 1, 2}},
+			{Arch: []iana.Arch{iana.EFI_X86_64, iana.EFI_BC}, BootFileName: "ipxe.efi", TFTPServerName: "10.3.1.3"},
+			{IPXE: true, BootFileName: "http://10.3.1.2/boot.ipxe"},
+		},
+	})
+	require.NoError(t, err)
+
+	discover := func(mac byte, modifiers ...dhcpv4.Modifier) dhcpv4.DHCPv4 {
+		modifiers = append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName))
+		dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, mac}, modifiers...)
+		require.NoError(t, err)
+		requestChan <- Request{
+			DHCPv4:        dr,
+			InterfaceName: "br1",
+			socket:        &socketFactory.mockSocket,
+		}
+		return <-responseChan
+	}
+
+	resp := discover(1, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC)))
+	require.Equal(t, "undionly.kpxe", resp.BootFileName)
+	require.Equal(t, "10.3.1.2", resp.ServerIPAddr.String())
+
+	resp = discover(2, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_BC)))
+	require.Equal(t, "ipxe.efi", resp.BootFileName)
+	require.Equal(t, "10.3.1.3", resp.ServerIPAddr.String())
+	require.Equal(t, "10.3.1.3", resp.TFTPServerName())
+
+	//iPXE gets its script instead of chainloading itself again
+	resp = discover(2, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_BC)),
+		dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE")))
+	require.Equal(t, "http://10.3.1.2/boot.ipxe", resp.BootFileName)
+
+	resp = discover(3, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_ARM64)))
+	require.Equal(t, "default.kpxe", resp.BootFileName)
+}
+
+func TestServer_HTTPBoot(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:       "10.3.1.0/24",
+		RangeFrom:    "10.3.1.10",
+		RangeTo:      "10.3.1.20",
+		Gateway:      "10.3.1.254",
+		LeaseTime:    3600,
+		BootFileName: "undionly.kpxe",
+		HTTPBootURL:  "https://10.3.1.2/ipxe.efi",
+	})
+	require.NoError(t, err)
+
+	dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016:UNDI:003001")),
+		dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP)))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, "https://10.3.1.2/ipxe.efi", resp.BootFileName)
+	require.Equal(t, "HTTPClient", resp.ClassIdentifier())
+
+	//PXE client
+	dr, err = dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 7},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, "undionly.kpxe", resp.BootFileName)
+	require.False(t, resp.Options.Has(dhcpv4.OptionClassIdentifier))
+}
+
+func TestServer_HTTPBootProfiles(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{Interface: "br1", Addr: "0.0.0.0"})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+	subnet := Subnet{
+		Subnet:      "10.3.1.0/24",
+		RangeFrom:   "10.3.1.10",
+		RangeTo:     "10.3.1.20",
+		LeaseTime:   3600,
+		HTTPBootURL: "https://10.3.1.2/ipxe.efi",
+		//catch-all profile serves TFTP clients only
+		BootProfiles: []BootProfile{{BootFileName: "undionly.kpxe", NextServer: net.IP{10, 3, 1, 3}}},
+	}
+	require.NoError(t, m.AddSubnet(subnet))
+	discover := func(mac byte, vendorClass string, arch iana.Arch) dhcpv4.DHCPv4 {
+		dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, mac},
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vendorClass)),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(arch)))
+		require.NoError(t, err)
+		requestChan <- Request{DHCPv4: dr, InterfaceName: "br1", socket: &socketFactory.mockSocket}
+		return <-responseChan
+	}
+
+	resp := discover(1, "HTTPClient:Arch:00016:UNDI:003001", iana.EFI_X86_64_HTTP)
+	require.Equal(t, "https://10.3.1.2/ipxe.efi", resp.BootFileName)
+	require.Equal(t, "10.3.1.1", resp.ServerIPAddr.String())
+	require.Equal(t, "HTTPClient", resp.ClassIdentifier())
+
+	resp = discover(2, "PXEClient:Arch:00000:UNDI:002001", iana.INTEL_X86PC)
+	require.Equal(t, "undionly.kpxe", resp.BootFileName)
+	require.Equal(t, "10.3.1.3", resp.ServerIPAddr.String())
+	require.False(t, resp.Options.Has(dhcpv4.OptionClassIdentifier))
+
+	//profile for HTTP boot architecture wins over HTTP boot URL
+	require.NoError(t, m.DeleteSubnet(subnet.Subnet))
+	subnet.BootProfiles = append([]BootProfile{{Arch: []iana.Arch{iana.EFI_X86_64_HTTP},
+		BootFileName: "http://10.3.1.4/shim.efi"}}, subnet.BootProfiles...)
+	require.NoError(t, m.AddSubnet(subnet))
+	resp = discover(3, "HTTPClient:Arch:00016:UNDI:003001", iana.EFI_X86_64_HTTP)
+	require.Equal(t, "http://10.3.1.4/shim.efi", resp.BootFileName)
+	require.Equal(t, "HTTPClient", resp.ClassIdentifier())
+}
+
+func TestServer_ClientClasses(t *testing.T) {
+	var saved []Response
+
+	m, socketFactory := newTestServer(t, ServerConfig{
+		CallbackSaveLeases: func(resps []Response) error {
+			saved = append(saved, resps...)
+			return nil
+		},
+	}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:       "10.3.1.0/24",
+		RangeFrom:    "10.3.1.10",
+		RangeTo:      "10.3.1.100",
+		Gateway:      "10.3.1.254",
+		LeaseTime:    3600,
+		BootFileName: "pxelinux.0",
+		Options:      []Option{{ID: 66, Type: "string", Value: "subnet"}},
+	})
+	require.NoError(t, err)
+
+	err = m.AddClientClass("default/pxe", ClientClass{
+		Name:  "pxe",
+		Match: ClientClassMatch{VendorClass: []string{"PXEClient"}},
+		Options: []Option{
+			{ID: 66, Type: "string", Value: "pxe"},
+			{ID: 67, Type: "string", Value: "pxe"},
+		},
+		LeaseTime: 600,
+	})
+	require.NoError(t, err)
+	err = m.AddClientClass("default/kvm", ClientClass{
+		Name:         "kvm",
+		Priority:     10,
+		Match:        ClientClassMatch{MACPrefix: []string{"52:54:00"}},
+		Options:      []Option{{ID: 66, Type: "string", Value: "kvm"}},
+		BootFileName: "undionly.kpxe",
+		RangeFrom:    "10.3.1.50",
+		RangeTo:      "10.3.1.60",
+	})
+	require.NoError(t, err)
+	err = m.AddClientClass("default/invalid", ClientClass{
+		Name:      "invalid",
+		Match:     ClientClassMatch{MACPrefix: []string{"52:54:00"}},
+		RangeFrom: "10.3.1.50",
+	})
+	require.Error(t, err)
+
+	discover := func(mac net.HardwareAddr, vendorClass string) dhcpv4.DHCPv4 {
+		dr, err := dhcpv4.NewDiscovery(mac,
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vendorClass)),
+			dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName))
+		require.NoError(t, err)
+		requestChan <- Request{
+			DHCPv4:        dr,
+			InterfaceName: "br1",
+			socket:        &socketFactory.mockSocket,
+		}
+		return <-responseChan
+	}
+
+	//no classes
+	resp := discover(net.HardwareAddr{1, 2, 3, 4, 5, 6}, "MSFT 5.0")
+	require.Equal(t, "10.3.1.10", resp.YourIPAddr.String())
+	require.Equal(t, "subnet", resp.TFTPServerName())
+	require.Equal(t, "pxelinux.0", resp.BootFileName)
+	require.Equal(t, 3600*time.Second, resp.IPAddressLeaseTime(0))
+
+	//pxe class
+	resp = discover(net.HardwareAddr{1, 2, 3, 4, 5, 7}, "PXEClient:Arch:00000:UNDI:002001")
+	require.Equal(t, "10.3.1.11", resp.YourIPAddr.String())
+	require.Equal(t, "pxe", resp.TFTPServerName())
+	require.Equal(t, 600*time.Second, resp.IPAddressLeaseTime(0))
+
+	//kvm overrides pxe, address from class pool
+	resp = discover(net.HardwareAddr{0x52, 0x54, 0, 1, 2, 3}, "PXEClient:Arch:00000:UNDI:002001")
+	require.Equal(t, "10.3.1.50", resp.YourIPAddr.String())
+	require.Equal(t, "kvm", resp.TFTPServerName())
+	require.Equal(t, "undionly.kpxe", resp.BootFileName)
+	require.Equal(t, 600*time.Second, resp.IPAddressLeaseTime(0))
+	require.Equal(t, []string{"kvm", "pxe"}, saved[len(saved)-1].Lease.Classes)
+
+	//class with the same name in another namespace is kept
+	err = m.AddClientClass("other/kvm", ClientClass{
+		Name:     "kvm",
+		Priority: 10,
+		Match:    ClientClassMatch{MACPrefix: []string{"52:54:00"}},
+		Options:  []Option{{ID: 66, Type: "string", Value: "other-kvm"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, m.DeleteClientClass("default/kvm"))
+	require.Error(t, m.DeleteClientClass("default/kvm"))
+	resp = discover(net.HardwareAddr{0x52, 0x54, 0, 1, 2, 5}, "MSFT 5.0")
+	require.Equal(t, "other-kvm", resp.TFTPServerName())
+	require.Equal(t, []string{"kvm"}, saved[len(saved)-1].Lease.Classes)
+
+	require.NoError(t, m.DeleteClientClass("other/kvm"))
+	resp = discover(net.HardwareAddr{0x52, 0x54, 0, 1, 2, 4}, "MSFT 5.0")
+	require.Equal(t, "10.3.1.13", resp.YourIPAddr.String())
+	require.Equal(t, "subnet", resp.TFTPServerName())
+}
+
+func TestServer_Expressions(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	_, err := CompileMatchExpression("mac + 1")
+	require.Error(t, err)
+	_, err = CompileMatchExpression("mac")
+	require.Error(t, err)
+	_, err = CompileValueExpression("unknown")
+	require.Error(t, err)
+
+	match, err := CompileMatchExpression("!mac.startsWith('02:')")
+	require.NoError(t, err)
+	hostName, err := CompileValueExpression("'node-' + mac.replace(':', '')")
+	require.NoError(t, err)
+	err = m.AddSubnet(Subnet{
+		Subnet:    "10.3.1.0/24",
+		RangeFrom: "10.3.1.10",
+		RangeTo:   "10.3.1.20",
+		Gateway:   "10.3.1.254",
+		LeaseTime: 3600,
+		Match:     match,
+		Options:   []Option{{ID: 12, Type: "string", Expression: hostName, AlwaysSend: true}},
+	})
+	require.NoError(t, err)
+
+	classMatch, err := CompileMatchExpression("'iPXE' in userClass && interface == 'br1'")
+	require.NoError(t, err)
+	err = m.AddClientClass("default/ipxe", ClientClass{
+		Name:         "ipxe",
+		Match:        ClientClassMatch{Expression: classMatch},
+		BootFileName: "http://10.3.1.2/boot.ipxe",
+	})
+	require.NoError(t, err)
+
+	dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		dhcpv4.WithUserClass("iPXE", false))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, "node-010203040506", resp.HostName())
+	require.Equal(t, "http://10.3.1.2/boot.ipxe", resp.BootFileName)
+
+	dr, err = dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 7})
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, "node-010203040507", resp.HostName())
+	require.Empty(t, resp.BootFileName)
+
+	//ignored by subnet expression
+	dr, err = dhcpv4.NewDiscovery(net.HardwareAddr{2, 2, 3, 4, 5, 6})
+	require.NoError(t, err)
+	_, err = m.GetResponse(Request{DHCPv4: dr, InterfaceName: "br1"})
+	require.Error(t, err)
+}
+
+func TestServer_VendorOptions(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	subnet := Subnet{
+		Subnet:    "10.3.1.0/24",
+		RangeFrom: "10.3.1.10",
+		RangeTo:   "10.3.1.20",
+		Gateway:   "10.3.1.254",
+		LeaseTime: 3600,
+		VendorOptions: []VendorOptions{{
+			VendorClass: "Cisco",
+			Options:     []Option{{ID: 0, Type: "string", Value: "bad"}},
+		}},
+	}
+	require.Error(t, m.AddSubnet(subnet))
+
+	subnet.VendorOptions = []VendorOptions{
+		{
+			VendorClass: "Cisco",
+			Options: []Option{
+				{ID: 1, Type: "ip", Value: "10.3.1.2"},
+				{ID: 2, Type: "string", Value: "cfg"},
+			},
+		},
+		{
+			Options: []Option{{ID: 1, Type: "uint8", Value: "1"}},
+		},
+	}
+	subnet.EnterpriseOptions = []EnterpriseOptions{{
+		EnterpriseNumber: 9,
+		Options:          []Option{{ID: 5, Type: "string", Value: "ztp"}},
+	}, {
+		EnterpriseNumber: 4491,
+		Options:          []Option{{ID: 2, Type: "string", Value: "docsis"}},
+	}}
+	require.NoError(t, m.AddSubnet(subnet))
+
+	dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 6},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("Cisco Systems, Inc.")),
+		dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 9, Data: []byte("switch")})),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionSubnetMask))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, []byte{1, 4, 10, 3, 1, 2, 2, 3, 'c', 'f', 'g'},
+		resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+	require.Equal(t, []byte{0, 0, 0, 9, 5, 5, 3, 'z', 't', 'p'},
+		resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+
+	//default vendor options, no enterprise numbers
+	dr, err = dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 7},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient")))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, []byte{1, 1, 1}, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+	require.False(t, resp.Options.Has(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+
+	//class vendor options take precedence
+	err = m.AddClientClass("default/pxe", ClientClass{
+		Name:  "pxe",
+		Match: ClientClassMatch{VendorClass: []string{"PXEClient"}},
+		VendorOptions: []VendorOptions{{
+			Options: []Option{{ID: 6, Type: "uint8", Value: "8"}},
+		}},
+	})
+	require.NoError(t, err)
+	dr, err = dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 8},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient")))
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, []byte{6, 1, 8}, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+}
+
+type mockProber struct {
+	inUse map[string]net.HardwareAddr
+}
+
+func (p *mockProber) Probe(method ProbeMethod, _ interfaceName, ip net.IP, _ time.Duration) (
+	bool, net.HardwareAddr, error) {
+	hw, ok := p.inUse[ip.String()]
+	if method == ProbeMethodICMP {
+		hw = nil
+	}
+	return ok, hw, nil
+}
+
+func TestServer_ConflictProbe(t *testing.T) {
+	saved := make(chan Lease, 16)
+	prober := &mockProber{inUse: map[string]net.HardwareAddr{
+		"10.3.1.10": {2, 0, 0, 0, 0, 1},
+		"10.3.1.11": {2, 0, 0, 0, 0, 2},
+	}}
+
+	m, socketFactory := newTestServer(t, ServerConfig{
+		CallbackSaveLeases: func(resps []Response) error {
+			for _, r := range resps {
+				saved <- *r.Lease
+			}
+			return nil
+		},
+		Prober: prober,
+	}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:       "10.3.1.0/24",
+		RangeFrom:    "10.3.1.10",
+		RangeTo:      "10.3.1.20",
+		Gateway:      "10.3.1.254",
+		LeaseTime:    3600,
+		ProbeTimeout: time.Millisecond * 100,
+	})
+	require.NoError(t, err)
+
+	dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	require.NoError(t, err)
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp := <-responseChan
+	require.Equal(t, "10.3.1.12", resp.YourIPAddr.String())
+
+	conflicts := map[string]Lease{}
+	for i := 0; i < 2; i++ {
+		lease := <-saved
+		conflicts[lease.IP.String()] = lease
+	}
+	require.True(t, conflicts["10.3.1.10"].Declined)
+	require.Equal(t, ProbeMethodARP, conflicts["10.3.1.10"].ConflictProbe)
+	require.Equal(t, "02:00:00:00:00:02", conflicts["10.3.1.11"].ConflictHWAddr.String())
+	offered := <-saved
+	require.Equal(t, "10.3.1.12", offered.IP.String())
+	require.False(t, offered.Declined)
+
+	//address of existing lease isn't probed again
+	prober.inUse = map[string]net.HardwareAddr{"10.3.1.12": nil}
+	requestChan <- Request{
+		DHCPv4:        dr,
+		InterfaceName: "br1",
+		socket:        &socketFactory.mockSocket,
+	}
+	resp = <-responseChan
+	require.Equal(t, "10.3.1.12", resp.YourIPAddr.String())
+}
+
+func TestServer_Renew(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br0",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	//subnet is routed, it is not on the receiving interface
+	err := m.AddSubnet(Subnet{
+		Subnet:    "10.3.1.0/24",
+		RangeFrom: "10.3.1.10",
+		RangeTo:   "10.3.1.13",
+		Gateway:   "10.3.1.254",
+		LeaseTime: 3600,
+	})
+	require.NoError(t, err)
+
+	renew := func(ciaddr string, dst net.IP) dhcpv4.DHCPv4 {
+		dr := &dhcpv4.DHCPv4{
+			OpCode:        dhcpv4.OpcodeBootRequest,
+			HWType:        iana.HWTypeEthernet,
+			TransactionID: dhcpv4.TransactionID{1, 2, 3, 4},
+			ClientHWAddr:  net.HardwareAddr{1, 2, 3, 4, 5, 6},
+			ClientIPAddr:  net.ParseIP(ciaddr),
+		}
+		dr.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+		req := Request{
+			DHCPv4:        dr,
+			InterfaceName: "br0",
+			Dst:           dst,
+			socket:        &socketFactory.mockSocket,
+		}
+		requestChan <- req
+		return <-responseChan
+	}
+
+	resp := renew("10.3.1.11", net.ParseIP("10.1.1.1"))
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	require.Equal(t, "10.3.1.11", resp.YourIPAddr.String())
+	require.Equal(t, "10.3.1.11", resp.ClientIPAddr.String())
+	require.Equal(t, &net.UDPAddr{IP: net.ParseIP("10.3.1.11"), Port: dhcpv4.ClientPort},
+		socketFactory.mockSocket.sentTo)
+
+	resp = renew("10.3.1.11", net.IPv4bcast)
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	require.Equal(t, "10.3.1.11", resp.ClientIPAddr.String())
+	require.Equal(t, "10.3.1.11", socketFactory.mockSocket.sentTo.IP.String())
+
+	//client is bound to another address
+	resp = renew("10.3.1.12", net.ParseIP("10.1.1.1"))
+	require.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	require.Nil(t, socketFactory.mockSocket.sentTo)
+}
+
+func TestRequestState(t *testing.T) {
+	newRequest := func(ciaddr net.IP, dst net.IP, modifiers ...dhcpv4.Modifier) Request {
+		dr, err := dhcpv4.New(append(modifiers, dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))...)
+		require.NoError(t, err)
+		dr.ClientIPAddr = ciaddr
+		return Request{DHCPv4: dr, Dst: dst}
+	}
+	serverID := dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("10.3.1.1")))
+	requestedIP := dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("10.3.1.10")))
+	ciaddr := net.ParseIP("10.3.1.10")
+
+	require.Equal(t, RequestStateSelecting,
+		requestState(newRequest(net.IPv4zero, net.IPv4bcast, serverID, requestedIP)))
+	require.Equal(t, RequestStateInitReboot, requestState(newRequest(net.IPv4zero, net.IPv4bcast, requestedIP)))
+	require.Equal(t, RequestStateRenewing, requestState(newRequest(ciaddr, net.ParseIP("10.3.1.1"))))
+	require.Equal(t, RequestStateRebinding, requestState(newRequest(ciaddr, net.IPv4bcast)))
+}
+
+func TestServer_RequestStates(t *testing.T) {
+	m, _ := newTestServer(t, ServerConfig{})
+	require.NoError(t, m.AddSubnet(Subnet{Subnet: "10.3.1.0/24", RangeFrom: "10.3.1.10", RangeTo: "10.3.1.20"}))
+	host := net.HardwareAddr{1, 2, 3, 4, 5, 1}
+	require.NoError(t, m.AddHost(Host{MAC: host.String(), IP: net.ParseIP("10.3.1.50")}))
+	request := func(mac net.HardwareAddr, requested string, modifiers ...dhcpv4.Modifier) (Response, error) {
+		dr, err := dhcpv4.New(append(modifiers, dhcpv4.WithHwAddr(mac),
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP(requested))))...)
+		require.NoError(t, err)
+		return m.GetResponse(Request{DHCPv4: dr, InterfaceName: "br1", Dst: net.IPv4bcast})
+	}
+
+	//INIT-REBOOT client unknown to this server is not answered
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 2}
+	_, err := request(mac, "10.3.1.10")
+	require.Error(t, err)
+	require.Nil(t, m.GetLease("10.3.1.0/24", mac.String()))
+
+	//SELECTING client which chose offer of another server is not answered
+	_, err = request(mac, "10.3.1.10", dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("10.3.1.2"))))
+	require.Error(t, err)
+	resp, err := request(mac, "10.3.1.10", dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("10.3.1.1"))))
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.Response.MessageType())
+
+	//known clients are answered in INIT-REBOOT
+	resp, err = request(mac, "10.3.1.10")
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.Response.MessageType())
+	resp, err = request(mac, "10.3.1.11")
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeNak, resp.Response.MessageType())
+	resp, err = request(host, "10.3.1.50")
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.Response.MessageType())
+}
+
+func TestServer_Timers(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{}, Listen{
+		Interface: "br1",
+		Addr:      "0.0.0.0",
+	})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	//absolute T2 must be less than minimal lease time
+	err := m.AddSubnet(Subnet{
+		Subnet:        "10.3.1.0/24",
+		RangeFrom:     "10.3.1.10",
+		RangeTo:       "10.3.1.13",
+		Gateway:       "10.3.1.254",
+		LeaseTime:     3600,
+		MinLeaseTime:  600,
+		RebindingTime: Timer{Seconds: 3000},
+	})
+	require.Error(t, err)
+
+	err = m.AddSubnet(Subnet{
+		Subnet:        "10.3.1.0/24",
+		RangeFrom:     "10.3.1.10",
+		RangeTo:       "10.3.1.13",
+		Gateway:       "10.3.1.254",
+		LeaseTime:     3600,
+		MinLeaseTime:  1200,
+		MaxLeaseTime:  7200,
+		RenewalTime:   Timer{Seconds: 300},
+		RebindingTime: Timer{Percent: 75},
+	})
+	require.NoError(t, err)
+	err = m.AddHost(Host{
+		Subnet:    "10.3.1.0/24",
+		MAC:       "01:02:03:04:05:08",
+		IP:        net.ParseIP("10.3.1.13"),
+		LeaseTime: 200,
+	})
+	require.NoError(t, err)
+
+	discover := func(mac net.HardwareAddr, leaseTime time.Duration) dhcpv4.DHCPv4 {
+		dr, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(leaseTime)))
+		require.NoError(t, err)
+		requestChan <- Request{
+			DHCPv4:        dr,
+			InterfaceName: "br1",
+			socket:        &socketFactory.mockSocket,
+		}
+		return <-responseChan
+	}
+
+	//requested lease time is clamped to min
+	resp := discover(net.HardwareAddr{1, 2, 3, 4, 5, 6}, time.Second*100)
+	require.Equal(t, time.Second*1200, resp.IPAddressLeaseTime(0))
+	require.Equal(t, time.Second*300, resp.IPAddressRenewalTime(0))
+	require.Equal(t, time.Second*900, resp.IPAddressRebindingTime(0))
+	require.Equal(t, 1200, m.GetLease("10.3.1.0/24", "01:02:03:04:05:06").LeaseTime)
+
+	//and to max
+	resp = discover(net.HardwareAddr{1, 2, 3, 4, 5, 7}, time.Hour*24)
+	require.Equal(t, time.Second*7200, resp.IPAddressLeaseTime(0))
+	require.Equal(t, time.Second*300, resp.IPAddressRenewalTime(0))
+	require.Equal(t, time.Second*5400, resp.IPAddressRebindingTime(0))
+
+	//lease time of host reservation is not negotiable, and T1 not fitting it is skipped
+	resp = discover(net.HardwareAddr{1, 2, 3, 4, 5, 8}, time.Hour*24)
+	require.Equal(t, "10.3.1.13", resp.YourIPAddr.String())
+	require.Equal(t, time.Second*200, resp.IPAddressLeaseTime(0))
+	require.False(t, resp.Options.Has(dhcpv4.OptionRenewTimeValue))
+	require.Equal(t, time.Second*150, resp.IPAddressRebindingTime(0))
+}
+
+func TestParseTimer(t *testing.T) {
+	timer, err := ParseTimer("87.5%")
+	require.NoError(t, err)
+	require.Equal(t, Timer{Percent: 87.5}, timer)
+	timer, err = ParseTimer("1800")
+	require.NoError(t, err)
+	require.Equal(t, Timer{Seconds: 1800}, timer)
+	for _, s := range []string{"100%", "0%", "-1", "1h"} {
+		_, err = ParseTimer(s)
+		require.Error(t, err, s)
+	}
+	require.Error(t, ValidateTimers(Timer{Percent: 90}, Timer{Percent: 50}, 0))
+	require.Error(t, ValidateTimers(Timer{Seconds: 600}, Timer{Seconds: 300}, 0))
+	require.NoError(t, ValidateTimers(Timer{Seconds: 600}, Timer{Percent: 50}, 0))
+	require.Error(t, ValidateTimers(Timer{Seconds: 600}, Timer{Percent: 50}, 1000))
+}
+
+func TestResponse_Target(t *testing.T) {
+	newResponse := func(msgType dhcpv4.MessageType, giaddr string, ciaddr string, broadcast bool,
+		replyType dhcpv4.MessageType) Response {
+		req, err := dhcpv4.New(dhcpv4.WithMessageType(msgType), dhcpv4.WithGatewayIP(net.ParseIP(giaddr)),
+			dhcpv4.WithClientIP(net.ParseIP(ciaddr)), dhcpv4.WithBroadcast(broadcast))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(replyType))
+		require.NoError(t, err)
+		if replyType != dhcpv4.MessageTypeNak && msgType != dhcpv4.MessageTypeInform {
+			resp.YourIPAddr = net.ParseIP("10.3.1.10")
+		}
+		return Response{Request: Request{DHCPv4: req}, Response: *resp}
+	}
+	for _, tc := range []struct {
+		name   string
+		resp   Response
+		target replyTarget
+	}{
+		{"relayed offer", newResponse(dhcpv4.MessageTypeDiscover, "10.3.1.1", "0.0.0.0", false,
+			dhcpv4.MessageTypeOffer), replyToRelay},
+		{"relayed nak", newResponse(dhcpv4.MessageTypeRequest, "10.3.1.1", "10.3.1.10", false,
+			dhcpv4.MessageTypeNak), replyToRelay},
+		{"relayed inform", newResponse(dhcpv4.MessageTypeInform, "10.3.1.1", "10.3.1.10", false,
+			dhcpv4.MessageTypeAck), replyToClientAddr},
+		{"nak", newResponse(dhcpv4.MessageTypeRequest, "0.0.0.0", "10.3.1.10", false,
+			dhcpv4.MessageTypeNak), replyBroadcast},
+		{"renew", newResponse(dhcpv4.MessageTypeRequest, "0.0.0.0", "10.3.1.10", true,
+			dhcpv4.MessageTypeAck), replyToClientAddr},
+		{"broadcast flag", newResponse(dhcpv4.MessageTypeDiscover, "0.0.0.0", "0.0.0.0", true,
+			dhcpv4.MessageTypeOffer), replyBroadcast},
+		{"unicast", newResponse(dhcpv4.MessageTypeDiscover, "0.0.0.0", "0.0.0.0", false,
+			dhcpv4.MessageTypeOffer), replyToClientHWAddr},
+	} {
+		require.Equal(t, tc.target, tc.resp.target(), tc.name)
+	}
+	//broadcast flag is echoed
+	resp := newResponse(dhcpv4.MessageTypeDiscover, "0.0.0.0", "0.0.0.0", true, dhcpv4.MessageTypeOffer)
+	require.True(t, resp.Response.IsBroadcast())
+}
+
+func TestServer_Relay(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:    "10.1.1.0/24",
+		RangeFrom: "10.1.1.10",
+		RangeTo:   "10.1.1.13",
+		Gateway:   "10.1.1.254",
+		LeaseTime: 3600,
+	})
+	require.NoError(t, err)
+
+	err = m.AddListen(Listen{
+		Name:      "relay",
+		Interface: "br0",
+		Relay:     &RelayConfig{Upstreams: []string{"10.1.1.1:67:1"}},
+	})
+	require.Error(t, err)
+	err = m.AddListen(Listen{
+		Name:      "relay",
+		Interface: "br0",
+		Relay: &RelayConfig{
+			//this server and another one
+			Upstreams: []string{"10.3.1.1", "192.0.2.1:1067"},
+			CircuitID: "{{.Interface}}",
+			RemoteID:  "{{.Hostname}}",
+		},
+	})
+	require.NoError(t, err)
+
+	dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	require.NoError(t, err)
+	requestChan <- Request{DHCPv4: dr, InterfaceName: "br0"}
+
+	//relayed request and offer of this server
+	var relayed, offer dhcpv4.DHCPv4
+	for i := 0; i < 2; i++ {
+		msg := <-responseChan
+		if msg.OpCode == dhcpv4.OpcodeBootRequest {
+			relayed = msg
+		} else {
+			offer = msg
+		}
+	}
+	hostname, _ := os.Hostname()
+	require.Equal(t, uint8(1), relayed.HopCount)
+	require.Equal(t, "10.1.1.1", relayed.GatewayIPAddr.String())
+	require.Equal(t, []byte("br0"), relayed.RelayAgentInfo().Get(dhcpv4.AgentCircuitIDSubOption))
+	require.Equal(t, []byte(hostname), relayed.RelayAgentInfo().Get(dhcpv4.AgentRemoteIDSubOption))
+	//relay agent information is the last option
+	encoded := bytes.TrimRight(encodeRelayed(&relayed), "\x00")
+	rai := relayed.Options.Get(dhcpv4.OptionRelayAgentInformation)
+	require.Equal(t, dhcpv4.OptionEnd.Code(), encoded[len(encoded)-1])
+	require.Equal(t, dhcpv4.OptionRelayAgentInformation.Code(), encoded[len(encoded)-len(rai)-3])
+
+	require.Equal(t, dhcpv4.MessageTypeOffer, offer.MessageType())
+	require.Equal(t, "10.1.1.10", offer.YourIPAddr.String())
+	require.Equal(t, "10.1.1.1", offer.GatewayIPAddr.String())
+	require.NotNil(t, offer.RelayAgentInfo())
+
+	//reply of upstream server is relayed to the client without relay agent information
+	requestChan <- Request{DHCPv4: &offer, InterfaceName: "br0"}
+	resp := <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+	require.Nil(t, resp.RelayAgentInfo())
+	require.Nil(t, socketFactory.mockSocket.sentTo)
+
+	//workers processing requests to this server are stopped with the relay
+	relay := m.listeners["relay"].(*RelayProcessor)
+	require.NoError(t, m.DeleteListen("relay"))
+	select {
+	case <-relay.local.done:
+	default:
+		t.Fatal("local request processor of deleted relay is not stopped")
+	}
+}
+
+func TestServer_ProxyDHCP(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{})
+	responseChan := socketFactory.responseChan
+
+	err := m.AddSubnet(Subnet{
+		Subnet:    "10.1.1.0/24",
+		RangeFrom: "10.1.1.10",
+		RangeTo:   "10.1.1.13",
+		LeaseTime: 3600,
+	})
+	require.NoError(t, err)
+
+	err = m.AddListen(Listen{Name: "proxy", Interface: "br0", ProxyDHCP: &ProxyDHCPConfig{}})
+	require.Error(t, err)
+	err = m.AddListen(Listen{
+		Name:      "proxy",
+		Interface: "br0",
+		ProxyDHCP: &ProxyDHCPConfig{
+			BootFileName: "undionly.kpxe",
+			BootProfiles: []BootProfile{{Arch: []iana.Arch{iana.EFI_X86_64}, BootFileName: "ipxe.efi"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, ":4011", socketFactory.mockSocket.listenAddress)
+	//requests are passed to handler directly, as both mock sockets read the same channel
+	p := m.listeners["proxy"].(*ProxyProcessor)
+
+	mac := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	dr, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	resp, err := p.getResponse(Request{DHCPv4: dr, InterfaceName: "br0"}, false)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	uuid := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	dr, err = dhcpv4.NewDiscovery(mac,
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+		dhcpv4.WithGeneric(dhcpv4.OptionClientMachineIdentifier, uuid))
+	require.NoError(t, err)
+	p.handle(Request{DHCPv4: dr, InterfaceName: "br0"}, false)
+	offer := <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeOffer, offer.MessageType())
+	require.True(t, isAddressZero(offer.YourIPAddr))
+	require.Equal(t, "10.1.1.1", offer.ServerIPAddr.String())
+	require.Equal(t, "10.1.1.1", offer.ServerIdentifier().String())
+	require.Equal(t, "undionly.kpxe", offer.BootFileName)
+	require.Equal(t, "PXEClient", offer.ClassIdentifier())
+	require.Equal(t, uuid, offer.Options.Get(dhcpv4.OptionClientMachineIdentifier))
+	require.Equal(t, []byte{6, 1, 8, 255}, offer.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+	require.Nil(t, socketFactory.mockSocket.sentTo)
+	require.Nil(t, m.GetLease("10.1.1.0/24", mac.String()))
+
+	dr.UpdateOption(dhcpv4.OptClientArch(iana.EFI_X86_64))
+	resp, err = p.getResponse(Request{DHCPv4: dr, InterfaceName: "br0"}, false)
+	require.NoError(t, err)
+	require.Equal(t, "ipxe.efi", resp.BootFileName)
+
+	//requests to DHCP server are not answered on DHCP port, and boot server discovery is answered on 4011
+	rr, err := dhcpv4.New(dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithClientIP(net.ParseIP("10.1.1.50")),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+		dhcpv4.WithGeneric(dhcpv4.OptionVendorSpecificInformation, []byte{71, 4, 0x80, 0, 0, 0, 255}))
+	require.NoError(t, err)
+	req := Request{
+		DHCPv4:        rr,
+		InterfaceName: "br0",
+		Src:           &net.UDPAddr{IP: net.ParseIP("10.1.1.50"), Port: 68},
+		Dst:           net.ParseIP("10.2.1.1"),
+	}
+	resp, err = p.getResponse(req, false)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	p.handle(req, true)
+	ack := <-responseChan
+	require.Equal(t, dhcpv4.MessageTypeAck, ack.MessageType())
+	require.Equal(t, "10.1.1.50", ack.ClientIPAddr.String())
+	require.True(t, isAddressZero(ack.YourIPAddr))
+	require.Equal(t, "10.2.1.1", ack.ServerIdentifier().String())
+	require.Equal(t, "undionly.kpxe", ack.BootFileName)
+	require.Equal(t, []byte{6, 1, 8, 71, 4, 0x80, 0, 0, 0, 255},
+		ack.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+	require.Equal(t, req.Src, socketFactory.mockSocket.sentTo)
+	require.Nil(t, m.GetLease("10.1.1.0/24", mac.String()))
+}
+
+func TestServer_EmbeddedTFTP(t *testing.T) {
+	//subnet can't use embedded TFTP server which is not running
+	disabled, _ := newTestServer(t, ServerConfig{})
+	require.Error(t, disabled.AddSubnet(Subnet{Subnet: "10.5.0.0/24", RangeFrom: "10.5.0.10", RangeTo: "10.5.0.20",
+		EmbeddedTFTP: true}))
+
+	m, socketFactory := newTestServer(t, ServerConfig{EmbeddedTFTP: true}, Listen{Interface: "br1", Addr: "0.0.0.0"})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+	for _, sn := range []Subnet{
+		{Subnet: "10.5.0.0/24", RangeFrom: "10.5.0.10", RangeTo: "10.5.0.20", BootFileName: "undionly.kpxe",
+			EmbeddedTFTP: true},
+		{Subnet: "10.6.0.0/24", RangeFrom: "10.6.0.10", RangeTo: "10.6.0.20", BootFileName: "undionly.kpxe"},
+		{Subnet: "10.3.1.0/24", RangeFrom: "10.3.1.10", RangeTo: "10.3.1.20", BootFileName: "undionly.kpxe",
+			EmbeddedTFTP: true},
+	} {
+		require.NoError(t, m.AddSubnet(sn))
+	}
+
+	for i, tc := range []struct {
+		giaddr     string
+		dst        string
+		nextServer string
+	}{
+		//relayed requests are unicast to this server, and it is next server of subnets with embedded TFTP only
+		{"10.5.0.1", "10.3.1.1", "10.3.1.1"},
+		{"10.6.0.1", "10.3.1.1", "0.0.0.0"},
+		//broadcast request is received on interface with address in the subnet
+		{"0.0.0.0", "255.255.255.255", "10.3.1.1"},
+	} {
+		dr, err := dhcpv4.NewDiscovery(net.HardwareAddr{1, 2, 3, 4, 5, byte(i)},
+			dhcpv4.WithGatewayIP(net.ParseIP(tc.giaddr)))
+		require.NoError(t, err)
+		requestChan <- Request{
+			DHCPv4:        dr,
+			InterfaceName: "br1",
+			Dst:           net.ParseIP(tc.dst),
+			socket:        &socketFactory.mockSocket,
+		}
+		resp := <-responseChan
+		require.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType(), tc.giaddr)
+		siaddr := resp.ServerIPAddr
+		if siaddr == nil {
+			siaddr = net.IPv4zero
+		}
+		require.Equal(t, tc.nextServer, siaddr.To4().String(), tc.giaddr)
+		require.Equal(t, "undionly.kpxe", resp.BootFileName)
+	}
+}
+
+func TestServer_IPXEScript(t *testing.T) {
+	m, socketFactory := newTestServer(t, ServerConfig{
+		IPXEScriptPort: 8080,
+	}, Listen{Interface: "br1", Addr: "0.0.0.0"})
+	requestChan, responseChan := socketFactory.requestChan, socketFactory.responseChan
+	err := m.AddSubnet(Subnet{Subnet: "10.3.1.0/24", RangeFrom: "10.3.1.10", RangeTo: "10.3.1.20",
+		BootFileName: "undionly.kpxe", IPXEScript: "boot.ipxe",
+		BootParams: map[string]string{"console": "ttyS0", "root": "/dev/nfs"}})
+	require.NoError(t, err)
+	host := net.HardwareAddr{1, 2, 3, 4, 5, 1}
+	err = m.AddHost(Host{MAC: host.String(), IP: net.ParseIP("10.3.1.50"), HostName: "node1",
+		BootFileName: "ipxe.efi", IPXEScript: "node1.ipxe", BootParams: map[string]string{"root": "/dev/sda1"}})
+	require.NoError(t, err)
+
+	discover := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) dhcpv4.DHCPv4 {
+		dr, err := dhcpv4.NewDiscovery(mac, modifiers...)
+		require.NoError(t, err)
+		requestChan <- Request{
+			DHCPv4:        dr,
+			InterfaceName: "br1",
+			Dst:           net.IPv4bcast,
+			socket:        &socketFactory.mockSocket,
+		}
+		return <-responseChan
+	}
+	arch := dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64))
+	ipxe := dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE"))
+
+	//firmware PXE clients chainload iPXE
+	resp := discover(host, arch)
+	require.Equal(t, "ipxe.efi", resp.BootFileName)
+	resp = discover(host, arch, ipxe)
+	require.Equal(t, "http://10.3.1.1:8080/ipxe/node1.ipxe?arch=efi-x64&mac=01%3A02%3A03%3A04%3A05%3A01",
+		resp.BootFileName)
+	dynamic := net.HardwareAddr{1, 2, 3, 4, 5, 2}
+	resp = discover(dynamic, arch)
+	require.Equal(t, "undionly.kpxe", resp.BootFileName)
+	resp = discover(dynamic, ipxe)
+	require.Equal(t, "http://10.3.1.1:8080/ipxe/boot.ipxe?mac=01%3A02%3A03%3A04%3A05%3A02", resp.BootFileName)
+
+	lease := m.FindLease(host.String(), net.ParseIP("10.3.1.50"))
+	require.NotNil(t, lease)
+	require.Equal(t, "10.3.1.50", lease.IP.String())
+	require.Equal(t, "node1", lease.HostName)
+	require.Equal(t, map[string]string{"console": "ttyS0", "root": "/dev/sda1"}, lease.BootParams)
+	require.Nil(t, m.FindLease(dynamic.String(), net.ParseIP("10.3.1.50")))
+	lease = m.FindLease(dynamic.String(), resp.YourIPAddr)
+	require.NotNil(t, lease)
+	require.Equal(t, map[string]string{"console": "ttyS0", "root": "/dev/nfs"}, lease.BootParams)
+	require.Nil(t, m.FindLease("01:02:03:04:05:03", resp.YourIPAddr))
+	require.Nil(t, m.FindLease(dynamic.String(), net.ParseIP("10.9.9.9")))
+}
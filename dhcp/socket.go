@@ -1,6 +1,7 @@
 package dhcp
 
 import (
+	"errors"
 	"fmt"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
@@ -16,8 +17,13 @@ type SocketFactory func(listenAddress string, listenInterface string, logger RLo
 type Socket interface {
 	NextRequest() (*Request, error)
 	//SendResp(Response) error //TODO
+	//SendResponse sends reply to relay agent (giaddr)
 	SendResponse(Request, dhcpv4.DHCPv4) error
+	//SendBroadcast sends reply to unconfigured client on the receiving interface, broadcast if broadcast flag is set
 	SendBroadcast(req Request, resp dhcpv4.DHCPv4) error
+	SendTo(req Request, resp dhcpv4.DHCPv4, addr *net.UDPAddr) error
+	//SendRelayed forwards request relayed by relay agent to DHCP server
+	SendRelayed(req *dhcpv4.DHCPv4, addr *net.UDPAddr) error
 	Close()
 }
 
@@ -74,11 +80,55 @@ func (s *UDPSocket) SendResponse(req Request, resp dhcpv4.DHCPv4) error {
 	}
 	s.log.Debugf("Set ServerID: %s", src)
 	resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.GenericOptionCode(54), Value: dhcpv4.IP(src)})
-	n, err := s.packetConn.WriteTo(resp.ToBytes(), nil, req.Src)
-	s.log.Infof("%d bytes sent %s -> %s", n, req.Dst, req.Src)
+	dst := &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
+	if addr, ok := req.Src.(*net.UDPAddr); ok && addr.IP.Equal(req.GatewayIPAddr) {
+		//relay agent may listen on its own port (RFC 8357)
+		dst = addr
+	}
+	n, err := s.packetConn.WriteTo(encodeReply(req.DHCPv4, &resp), nil, dst)
+	s.log.Infof("%d bytes sent %s -> %s", n, req.Dst, dst)
+	return err
+}
+
+func (s *UDPSocket) SendTo(req Request, resp dhcpv4.DHCPv4, addr *net.UDPAddr) error {
+	if isAddressZero(resp.ServerIdentifier()) {
+		src, err := getSrcAddr(addr.IP)
+		if err != nil {
+			return err
+		}
+		s.log.Debugf("Set ServerID: %s", src)
+		resp.UpdateOption(dhcpv4.OptServerIdentifier(src))
+	}
+	n, err := s.packetConn.WriteTo(encodeReply(req.DHCPv4, &resp), nil, addr)
+	s.log.Infof("%d bytes sent -> %s", n, addr)
 	return err
 }
 
+func (s *UDPSocket) SendRelayed(req *dhcpv4.DHCPv4, addr *net.UDPAddr) error {
+	n, err := s.packetConn.WriteTo(encodeRelayed(req), nil, addr)
+	s.log.Infof("%d bytes relayed -> %s", n, addr)
+	return err
+}
+
+// serveSocket passes requests read from the socket to handle until the socket is closed
+func serveSocket(socket Socket, log RLogger, handle func(req *Request)) error {
+	for {
+		req, err := socket.NextRequest()
+		if err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && errors.Is(opErr.Err, net.ErrClosed) {
+				return nil
+			}
+			log.Errorf(err, "Error reading packet")
+			if opErr != nil && !opErr.Temporary() {
+				return err
+			}
+			continue
+		}
+		handle(req)
+	}
+}
+
 func getSrcAddr(dst net.IP) (src net.IP, err error) {
 	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{
 		IP:   dst,
@@ -0,0 +1,142 @@
+package dhcp
+
+import (
+	"fmt"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"golang.org/x/net/ipv6"
+	"net"
+	"strconv"
+)
+
+type Socket6Factory func(listenAddress string, listenInterface string, logger RLogger) (Socket6, error)
+
+type Socket6 interface {
+	NextRequest() (*Request6, error)
+	SendTo(resp dhcpv6.DHCPv6, addr net.Addr) error
+	Close()
+}
+
+type UDPSocket6 struct {
+	udpConn    *net.UDPConn
+	packetConn *ipv6.PacketConn
+
+	log RLogger
+}
+
+func (s *UDPSocket6) Close() {
+	err := s.packetConn.Close()
+	if err != nil {
+		s.log.Errorf(err, "failed to close socket")
+	}
+}
+
+func (s *UDPSocket6) NextRequest() (*Request6, error) {
+	buf := make([]byte, 1<<16)
+	n, cm, src, err := s.packetConn.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	i, err := net.InterfaceByIndex(cm.IfIndex)
+	if err != nil {
+		return nil, err
+	}
+	req := Request6{
+		Src:           src,
+		InterfaceName: interfaceName(i.Name),
+		socket:        s,
+	}
+	req.DHCPv6, err = dhcpv6.FromBytes(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	s.log.Infof("%s", req.Summary())
+	return &req, nil
+}
+
+func (s *UDPSocket6) SendTo(resp dhcpv6.DHCPv6, addr net.Addr) error {
+	n, err := s.packetConn.WriteTo(resp.ToBytes(), nil, addr)
+	s.log.Infof("%d bytes sent -> %s", n, addr)
+	return err
+}
+
+// parseListenAddress6 parses "addr", "[addr]:port" or empty string as listen address
+func parseListenAddress6(listenAddress string) (*net.UDPAddr, error) {
+	addr := &net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6.DefaultServerPort}
+	if listenAddress == "" {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		host = listenAddress
+	} else {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		addr.Port = int(p)
+	}
+	if host != "" {
+		addr.IP = net.ParseIP(host)
+		if addr.IP == nil || addr.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid listen address %s", listenAddress)
+		}
+	}
+	return addr, nil
+}
+
+func NewUDPSocket6(listenAddress string, listenInterface string, logger RLogger) (Socket6, error) {
+	ifname := "*"
+	laddr := "*"
+	if listenInterface != "" {
+		ifname = listenInterface
+	}
+	if listenAddress != "" {
+		laddr = listenAddress
+	}
+	addr, err := parseListenAddress6(listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	socket := UDPSocket6{
+		log: logger.WithName(fmt.Sprintf("socket6[%s:%s]", ifname, laddr)),
+	}
+	socket.udpConn, err = server6.NewIPv6UDPConn(listenInterface, addr)
+	if err != nil {
+		return nil, err
+	}
+	socket.packetConn = ipv6.NewPacketConn(socket.udpConn)
+	err = socket.packetConn.SetControlMessage(ipv6.FlagInterface, true)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IP.IsUnspecified() {
+		return &socket, nil
+	}
+
+	//clients and relay agents send to All_DHCP_Relay_Agents_and_Servers
+	var ifs []net.Interface
+	if listenInterface != "" {
+		i, err := net.InterfaceByName(listenInterface)
+		if err != nil {
+			return nil, err
+		}
+		ifs = append(ifs, *i)
+	} else {
+		ifs, err = net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+	group := &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers}
+	for i := range ifs {
+		if ifs[i].Flags&net.FlagMulticast == 0 || ifs[i].Flags&net.FlagUp == 0 {
+			continue
+		}
+		err = socket.packetConn.JoinGroup(&ifs[i], group)
+		if err != nil {
+			socket.log.Errorf(err, "failed to join %s on %s", group.IP, ifs[i].Name)
+		}
+	}
+	return &socket, nil
+}
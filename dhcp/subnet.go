@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/insomniacslk/dhcp/dhcpv4"
-	"log"
 	"net"
 	"strconv"
 	"strings"
@@ -13,7 +12,8 @@ import (
 )
 
 const (
-	defaultLeaseTime = 14400 //4 hours
+	defaultLeaseTime             = 14400 //4 hours
+	defaultDeclineQuarantineTime = 86400 //24 hours
 )
 
 func (s *Subnet) Contains(ip net.IP) bool {
@@ -37,6 +37,22 @@ func InitializeSubnet(subnet *Subnet, addrs LocalIPAddresses) error {
 	if subnet.LeaseTime == 0 {
 		subnet.LeaseTime = defaultLeaseTime
 	}
+	if subnet.DeclineQuarantineTime == 0 {
+		subnet.DeclineQuarantineTime = defaultDeclineQuarantineTime
+	}
+	switch subnet.ClientIDPolicy {
+	case "":
+		subnet.ClientIDPolicy = ClientIDPolicyMAC
+	case ClientIDPolicyMAC, ClientIDPolicyClientID, ClientIDPolicyClientIDWithMACFallback:
+	default:
+		return fmt.Errorf("invalid client id policy %q", subnet.ClientIDPolicy)
+	}
+	if err = subnet.validateLeaseTimes(); err != nil {
+		return err
+	}
+	if err = ValidateVendorOptions(subnet.VendorOptions, subnet.EnterpriseOptions); err != nil {
+		return err
+	}
 	sn := strings.Split(string(subnet.Subnet), "/")
 	if len(sn) != 2 {
 		return fmt.Errorf("invalid subnet %q (%v)", subnet.Subnet, subnet)
@@ -75,14 +91,14 @@ func (s *Subnet) AddLease(l *Lease) {
 	ip := l.IP.String()
 	old, ok := s.leaseCache[ip]
 	if ok {
-		delete(s.leaseCache, old.MAC)
+		delete(s.leaseCache, old.Key())
 	}
 	s.leaseCache[ip] = l
-	s.leaseCache[l.MAC] = l
+	s.leaseCache[l.Key()] = l
 }
 
 func (s *Subnet) DeleteHost(host Host) {
-	delete(s.leaseCache, host.MAC)
+	delete(s.leaseCache, leaseKey(host.MAC, host.ClientID))
 	delete(s.leaseCache, host.IP.String())
 }
 
@@ -91,57 +107,256 @@ func (s *Subnet) AddHost(h Host) {
 	defer s.leaseCacheMutex.Unlock()
 	lease := &Lease{
 		MAC:            h.MAC,
+		ClientID:       h.ClientID,
 		IP:             h.IP,
 		NetMask:        s.netMask,
 		Gateway:        h.Gateway,
 		ServerHostName: h.ServerHostName,
 		BootFileName:   h.BootFileName,
+		BootProfiles:   h.BootProfiles,
+		HTTPBootURL:    h.HTTPBootURL,
 		DNS:            h.DNS,
 		Options:        h.Options,
 		LeaseTime:      h.LeaseTime,
 		HostName:       h.HostName,
+		RenewalTime:    h.RenewalTime,
+		RebindingTime:  h.RebindingTime,
+		IPXEScript:     h.IPXEScript,
+		BootParams:     mergeBootParams(s.BootParams, h.BootParams),
+		ServerId:       s.serverIPAddress,
+		Static:         true,
+	}
+	if lease.BootFileName == "" && lease.BootProfiles == nil && lease.HTTPBootURL == "" && lease.IPXEScript == "" {
+		lease.BootProfiles = s.BootProfiles
+		lease.HTTPBootURL = s.HTTPBootURL
+		lease.IPXEScript = s.IPXEScript
 	}
 	s.AddLease(lease)
 }
 
-func (s *Subnet) GetLeaseForRequest(req *dhcpv4.DHCPv4) *Lease {
+// mergeBootParams returns subnet boot parameters overridden by host ones
+func mergeBootParams(subnet map[string]string, host map[string]string) map[string]string {
+	if len(host) == 0 {
+		return subnet
+	}
+	params := make(map[string]string, len(subnet)+len(host))
+	for k, v := range subnet {
+		params[k] = v
+	}
+	for k, v := range host {
+		params[k] = v
+	}
+	return params
+}
+
+func (s *Subnet) inRange(ip net.IP) bool {
+	i, err := ParseIPv4(ip.String())
+	if err != nil {
+		return false
+	}
+	return i >= s.iPFrom && i <= s.iPTo
+}
+
+// requestClientID returns client identifier (option 61) of the request or empty string
+func requestClientID(req *dhcpv4.DHCPv4) string {
+	return formatHex(req.Options.Get(dhcpv4.OptionClientIdentifier))
+}
+
+func (s *Subnet) bindsByClientID() bool {
+	return s.ClientIDPolicy == ClientIDPolicyClientID || s.ClientIDPolicy == ClientIDPolicyClientIDWithMACFallback
+}
+
+// findLease looks up client's lease according to client id policy.
+// Static reservations are matched by client id and then by MAC regardless of policy.
+func (s *Subnet) findLease(mac string, clientID string) (*Lease, bool) {
+	if clientID != "" {
+		lease, ok := s.leaseCache[leaseKey(mac, clientID)]
+		if ok && (lease.Static || s.bindsByClientID()) {
+			return lease, true
+		}
+	}
+	lease, ok := s.leaseCache[mac]
+	if !ok {
+		return nil, false
+	}
+	if lease.Static || clientID == "" || s.ClientIDPolicy != ClientIDPolicyClientID {
+		return lease, true
+	}
+	return nil, false
+}
+
+// knowsClient returns true if subnet has record of the client: its lease or host reservation
+func (s *Subnet) knowsClient(req *dhcpv4.DHCPv4) bool {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	_, ok := s.findLease(req.ClientHWAddr.String(), requestClientID(req))
+	return ok
+}
+
+// isRapidCommit returns true if DISCOVER should be answered with ACK right away (RFC 4039)
+func (s *Subnet) isRapidCommit(req *dhcpv4.DHCPv4) bool {
+	return s.RapidCommit &&
+		req.MessageType() == dhcpv4.MessageTypeDiscover &&
+		req.Options.Has(dhcpv4.OptionRapidCommit)
+}
+
+func (s *Subnet) newClientLease(mac string, clientID string, ip net.IP, probe bool) *Lease {
+	lease := s.NewLease(mac, ip)
+	if s.bindsByClientID() {
+		lease.ClientID = clientID
+	}
+	lease.probePending = probe
+	return lease
+}
+
+// cachedLease returns cached lease the snapshot was taken from, or nil if its address was
+// reassigned or reaped since. Cache must be locked
+func (s *Subnet) cachedLease(snapshot *Lease) *Lease {
+	l, ok := s.leaseCache[snapshot.IP.String()]
+	if !ok || l.Key() != snapshot.Key() {
+		return nil
+	}
+	return l
+}
+
+func (s *Subnet) probeDone(lease *Lease) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	if l := s.cachedLease(lease); l != nil {
+		l.probePending = false
+	}
+}
+
+// markConflict quarantines address found in use by probe, like address declined by client.
+// Returned lease is a copy to be saved.
+func (s *Subnet) markConflict(lease *Lease, method ProbeMethod, hwAddr net.HardwareAddr) *Lease {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	now := time.Now()
+	cached := s.cachedLease(lease)
+	if cached != nil {
+		lease = cached
+	}
+	conflict := *lease
+	conflict.Declined = true
+	conflict.AckSent = false
+	conflict.probePending = false
+	conflict.LastUpdate = now
+	conflict.QuarantinedUntil = now.Add(time.Second * time.Duration(s.DeclineQuarantineTime))
+	conflict.ConflictProbe = method
+	conflict.ConflictHWAddr = hwAddr
+	if cached != nil {
+		*cached = conflict
+		if l, ok := s.leaseCache[cached.Key()]; ok && l == cached {
+			delete(s.leaseCache, cached.Key())
+		}
+	}
+	return &conflict
+}
+
+// GetLeaseForRequest returns copy of the client's lease or *NakError if request can't be satisfied
+func (s *Subnet) GetLeaseForRequest(req *dhcpv4.DHCPv4) (*Lease, error) {
+	return s.GetLeaseForClassifiedRequest(req, nil, LeaseBinding{})
+}
+
+// GetLeaseForClassifiedRequest is GetLeaseForRequest for client matched by client classes.
+// New address is allocated from pools of the classes if any of them restricts pool within subnet range.
+// Binding is recorded on the cached lease while cache is locked, and copy taken under the lock is returned
+func (s *Subnet) GetLeaseForClassifiedRequest(req *dhcpv4.DHCPv4, classes []*ClientClass,
+	binding LeaseBinding) (*Lease, error) {
 	s.leaseCacheMutex.Lock()
 	defer s.leaseCacheMutex.Unlock()
+	lease, err := s.allocateLease(req, classes)
+	if err != nil {
+		return nil, err
+	}
+	if !lease.Static && binding.LeaseTime != 0 {
+		lease.LeaseTime = binding.LeaseTime
+	}
+	if binding.RelayInfo != nil {
+		lease.CircuitID = formatRelayID(binding.RelayInfo.CircuitID)
+		lease.RemoteID = formatRelayID(binding.RelayInfo.RemoteID)
+	}
+	lease.Classes = classNames(classes)
+	//lease is acknowledged right away unless it is offered
+	lease.AckSent = req.MessageType() == dhcpv4.MessageTypeRequest || s.isRapidCommit(req)
+	snapshot := *lease
+	return &snapshot, nil
+}
+
+// allocateLease returns cached lease of the client or allocates new one. Cache must be locked
+func (s *Subnet) allocateLease(req *dhcpv4.DHCPv4, classes []*ClientClass) (*Lease, error) {
 	var (
 		lease            *Lease
-		oldestLease      *Lease
 		ok               bool
 		requestedAddress net.IP
 	)
 	mac := req.ClientHWAddr.String()
+	clientID := requestClientID(req)
+	isRequest := req.MessageType() == dhcpv4.MessageTypeRequest
+	//new addresses offered to DISCOVER are probed for conflicts first
+	probe := s.ProbeTimeout > 0 && req.MessageType() == dhcpv4.MessageTypeDiscover
+	pools := s.classPools(classes)
 
 	//Check if lease is in cache. Make sure if requested IP matched. Return NAK otherwise
-	requestedAddress = req.RequestedIPAddress()
-	lease, ok = s.leaseCache[mac]
+	requestedAddress = clientRequestedAddress(req)
+	lease, ok = s.findLease(mac, clientID)
 	if ok {
-		if !isAddressZero(requestedAddress) && !requestedAddress.Equal(lease.IP) {
-			log.Printf("requested address is not available: %s (%s)", requestedAddress, lease.IP)
-			//TODO: send NAK
-			return nil
+		if isRequest && !isAddressZero(requestedAddress) && !requestedAddress.Equal(lease.IP) {
+			return nil, newNakError(NakReasonAddressMismatch,
+				"requested address %s does not match lease %s", requestedAddress, lease.IP)
+		}
+		if !lease.Static && lease.ClientID == "" && clientID != "" && s.bindsByClientID() {
+			//lease found by MAC is taken over by client id
+			delete(s.leaseCache, mac)
+			lease.ClientID = clientID
+			s.leaseCache[lease.Key()] = lease
 		}
-		return lease
+		if isRequest {
+			lease.probePending = false
+		}
+		if lease.Released || isRequest || s.isRapidCommit(req) {
+			lease.Released = false
+			lease.LastUpdate = time.Now()
+		}
+		return lease, nil
 	}
 
 	//Lease is not in cache, so this is a new discovery request
 	//Check if requested address is available
 	if !isAddressZero(requestedAddress) {
 		lease, ok = s.leaseCache[requestedAddress.String()]
-		if ok {
-			if lease.IsExpired() {
-				lease = s.NewLease(mac, requestedAddress)
-				s.AddLease(lease)
-				return lease
-			} else {
-				log.Println("requested address is not available")
-				return nil
+		switch {
+		case !s.Contains(requestedAddress):
+			if isRequest {
+				return nil, newNakError(NakReasonWrongSubnet,
+					"requested address %s is not in subnet %s", requestedAddress, s.Subnet)
+			}
+		case ok && !lease.isReusable():
+			if isRequest {
+				return nil, newNakError(NakReasonAddressNotAvailable,
+					"requested address %s is not available", requestedAddress)
+			}
+		case !s.inRange(requestedAddress):
+			if isRequest {
+				return nil, newNakError(NakReasonAddressNotAvailable,
+					"requested address %s is out of range", requestedAddress)
+			}
+		case len(pools) > 0 && !inPools(requestedAddress, pools):
+			if isRequest {
+				return nil, newNakError(NakReasonAddressNotAvailable,
+					"requested address %s is out of pools of client classes", requestedAddress)
 			}
+		default:
+			lease = s.newClientLease(mac, clientID, requestedAddress, probe)
+			s.AddLease(lease)
+			return lease, nil
 		}
-		return s.NewLease(mac, requestedAddress)
+		//Requested address in DISCOVER is only a hint, so pick one from range
+	}
+
+	if len(pools) > 0 {
+		return s.allocateFromPools(mac, clientID, pools, probe)
 	}
 
 	//No address requested. Let's pick one from range
@@ -150,35 +365,49 @@ func (s *Subnet) GetLeaseForRequest(req *dhcpv4.DHCPv4) *Lease {
 	} else {
 		s.incrementCurrentIP()
 	}
-	expiredTime := time.Now().Add(-time.Second * time.Duration(s.LeaseTime))
 	firstIp := s.currentIP
 	for {
 		lease, ok = s.leaseCache[s.currentIP.String()]
-		if !ok {
-			lease = s.NewLease(mac, net.ParseIP(s.currentIP.String()))
+		if !ok || lease.isReusable() {
+			lease = s.newClientLease(mac, clientID, net.ParseIP(s.currentIP.String()), probe)
 			s.AddLease(lease)
-			return lease
-		}
-		if lease.LastUpdate.Before(expiredTime) {
-			if oldestLease == nil {
-				oldestLease = lease
-			} else {
-				if oldestLease.LastUpdate.After(lease.LastUpdate) {
-					oldestLease = lease
-				}
-			}
+			return lease, nil
 		}
 		s.incrementCurrentIP()
 		if firstIp == s.currentIP {
-			if oldestLease != nil {
-				return oldestLease
-			} else {
-				log.Println("No available addresses in pool")
-				//TODO: send NAK
-				return nil
+			return nil, newNakError(NakReasonPoolExhausted, "no available addresses in pool %s", s.Subnet)
+		}
+	}
+}
+
+// allocateFromPools returns new lease from the first pool having free address
+func (s *Subnet) allocateFromPools(mac string, clientID string, pools []*ClientClass, probe bool) (*Lease, error) {
+	for _, pool := range pools {
+		for ip := pool.iPFrom; ip <= pool.iPTo; ip++ {
+			lease, ok := s.leaseCache[ip.String()]
+			if !ok || lease.isReusable() {
+				lease = s.newClientLease(mac, clientID, net.ParseIP(ip.String()), probe)
+				s.AddLease(lease)
+				return lease, nil
 			}
 		}
 	}
+	return nil, newNakError(NakReasonPoolExhausted, "no available addresses in pools of client classes %v",
+		classNames(pools))
+}
+
+// GetInformLease returns copy of static host lease or new lease with subnet defaults.
+// Returned lease is not added to cache.
+func (s *Subnet) GetInformLease(req *dhcpv4.DHCPv4, ip net.IP) *Lease {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	mac := req.ClientHWAddr.String()
+	lease, ok := s.findLease(mac, requestClientID(req))
+	if ok && lease.Static {
+		hostLease := *lease
+		return &hostLease
+	}
+	return s.NewLease(mac, ip)
 }
 
 func (s *Subnet) NewLease(mac string, ip net.IP) *Lease {
@@ -193,15 +422,93 @@ func (s *Subnet) NewLease(mac string, ip net.IP) *Lease {
 		DNS:            s.DNS,
 		LeaseTime:      s.LeaseTime,
 		BootFileName:   s.BootFileName,
+		BootProfiles:   s.BootProfiles,
+		HTTPBootURL:    s.HTTPBootURL,
+		IPXEScript:     s.IPXEScript,
+		BootParams:     s.BootParams,
 		ServerHostName: s.ServerHostName,
 		ServerId:       s.serverIPAddress,
 	}
 }
 
+// ReleaseLease marks lease as released, so the address may be given to another client.
+// Returned lease is a copy to be saved.
+func (s *Subnet) ReleaseLease(req *dhcpv4.DHCPv4, ip net.IP) (*Lease, error) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	mac := req.ClientHWAddr.String()
+	lease, ok := s.findLease(mac, requestClientID(req))
+	if !ok {
+		return nil, fmt.Errorf("no lease found for %s (%s)", mac, ip)
+	}
+	if !lease.IP.Equal(ip) {
+		return nil, fmt.Errorf("client %s released %s but holds lease for %s", mac, ip, lease.IP)
+	}
+	lease.Released = true
+	lease.AckSent = false
+	lease.LastUpdate = time.Now()
+	released := *lease
+	return &released, nil
+}
+
+// DeclineLease quarantines address declined by the client, so it won't be offered to anybody
+// until quarantine time passes. Returned lease is a copy to be saved.
+func (s *Subnet) DeclineLease(req *dhcpv4.DHCPv4, ip net.IP) (*Lease, error) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	mac := req.ClientHWAddr.String()
+	lease, ok := s.findLease(mac, requestClientID(req))
+	if !ok {
+		return nil, fmt.Errorf("no lease found for %s (%s)", mac, ip)
+	}
+	if !lease.IP.Equal(ip) {
+		return nil, fmt.Errorf("client %s declined %s but holds lease for %s", mac, ip, lease.IP)
+	}
+	now := time.Now()
+	declined := *lease
+	declined.Declined = true
+	declined.AckSent = false
+	declined.LastUpdate = now
+	declined.QuarantinedUntil = now.Add(time.Second * time.Duration(s.DeclineQuarantineTime))
+	if lease.Static {
+		//static address can't be moved, so just report the conflict
+		return &declined, nil
+	}
+	*lease = declined
+	delete(s.leaseCache, lease.Key())
+	return &declined, nil
+}
+
+// ReapExpiredLeases removes expired, released and no longer quarantined dynamic leases from cache.
+// Copies of removed leases which should be deleted from status are returned.
+func (s *Subnet) ReapExpiredLeases() []*Lease {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	var expired []*Lease
+	for key, lease := range s.leaseCache {
+		//each dynamic lease is indexed by both ip and client key, so visit it once
+		if key != lease.IP.String() || !lease.isReusable() {
+			continue
+		}
+		delete(s.leaseCache, key)
+		if l, ok := s.leaseCache[lease.Key()]; ok && l == lease {
+			delete(s.leaseCache, lease.Key())
+		}
+		if lease.Released {
+			//already removed from status
+			continue
+		}
+		e := *lease
+		e.Expired = true
+		expired = append(expired, &e)
+	}
+	return expired
+}
+
 func (s *Subnet) DeleteLease(lease *Lease) error {
 	s.leaseCacheMutex.Lock()
 	defer s.leaseCacheMutex.Unlock()
-	delete(s.leaseCache, lease.MAC)
+	delete(s.leaseCache, lease.Key())
 	delete(s.leaseCache, lease.IP.String())
 	return nil
 }
@@ -0,0 +1,421 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const maxPDPoolBits = 32
+
+var errNoBinding = errors.New("no binding")
+
+// iaRequest is a request for address (IA_NA) or delegated prefix (IA_PD) of client's identity association
+type iaRequest struct {
+	DUID        string
+	IAID        uint32
+	MAC         string
+	PeerAddress net.IP
+	Hint        net.IP
+	HintPrefix  *net.IPNet
+	Allocate    bool //allocate address if client has no binding (SOLICIT, REQUEST)
+	Commit      bool //client is going to use the address (REQUEST, RENEW, REBIND)
+}
+
+func leaseKey6(duid string, iaid uint32) string {
+	return fmt.Sprintf("duid:%s/%d", duid, iaid)
+}
+
+func prefixKey6(duid string, iaid uint32) string {
+	return fmt.Sprintf("pd:%s/%d", duid, iaid)
+}
+
+// hostKey6 is a key of DHCPv6 host reservation: DUID if set, MAC otherwise
+func hostKey6(mac string, duid string) string {
+	if duid != "" {
+		return "duid:" + duid
+	}
+	return mac
+}
+
+func InitializeSubnet6(subnet *Subnet6) error {
+	var err error
+	_, subnet.ipNet, err = net.ParseCIDR(string(subnet.Subnet))
+	if err != nil {
+		return err
+	}
+	if subnet.ipNet.IP.To4() != nil {
+		return fmt.Errorf("invalid ipv6 subnet %q", subnet.Subnet)
+	}
+	subnet.ipFrom, err = parseIPv6(subnet.RangeFrom)
+	if err != nil {
+		return err
+	}
+	subnet.ipTo, err = parseIPv6(subnet.RangeTo)
+	if err != nil {
+		return err
+	}
+	if !subnet.Contains(subnet.ipFrom) || !subnet.Contains(subnet.ipTo) {
+		return fmt.Errorf("range %s-%s is not in subnet %s", subnet.RangeFrom, subnet.RangeTo, subnet.Subnet)
+	}
+	if compareIPv6(subnet.ipFrom, subnet.ipTo) > 0 {
+		return errors.New("from > to")
+	}
+	if subnet.LeaseTime == 0 {
+		subnet.LeaseTime = defaultLeaseTime
+	}
+	if subnet.PDPrefix != "" {
+		_, subnet.pdNet, err = net.ParseCIDR(subnet.PDPrefix)
+		if err != nil {
+			return err
+		}
+		ones, bits := subnet.pdNet.Mask.Size()
+		if bits != 8*net.IPv6len {
+			return fmt.Errorf("invalid ipv6 delegation prefix %q", subnet.PDPrefix)
+		}
+		if subnet.PDLength <= ones || subnet.PDLength > bits || subnet.PDLength-ones > maxPDPoolBits {
+			return fmt.Errorf("invalid delegated prefix length %d for %s", subnet.PDLength, subnet.PDPrefix)
+		}
+		subnet.pdSize = 1 << (subnet.PDLength - ones)
+	}
+	subnet.leaseCache = make(map[string]*Lease)
+	subnet.leaseCacheMutex = &sync.Mutex{}
+	return nil
+}
+
+// prefixAt returns i-th prefix of delegation pool
+func (s *Subnet6) prefixAt(i uint64) *net.IPNet {
+	offset := new(big.Int).Lsh(new(big.Int).SetUint64(i), uint(8*net.IPv6len-s.PDLength))
+	return &net.IPNet{
+		IP:   addIPv6(s.pdNet.IP, offset),
+		Mask: net.CIDRMask(s.PDLength, 8*net.IPv6len),
+	}
+}
+
+// isPoolPrefix returns true if prefix is one of delegation pool prefixes
+func (s *Subnet6) isPoolPrefix(prefix *net.IPNet) bool {
+	ones, _ := prefix.Mask.Size()
+	return s.pdNet != nil && ones == s.PDLength && s.pdNet.Contains(prefix.IP) && prefix.IP.Mask(prefix.Mask).Equal(prefix.IP)
+}
+
+func (s *Subnet6) Contains(ip net.IP) bool {
+	return s.ipNet.Contains(ip)
+}
+
+// hasHost returns true if reserved address is in subnet or reserved prefix is in delegation pool
+func (s *Subnet6) hasHost(h Host) bool {
+	if h.IP != nil {
+		return s.Contains(h.IP)
+	}
+	return h.Prefix != nil && s.pdNet != nil && s.pdNet.Contains(h.Prefix.IP)
+}
+
+func (s *Subnet6) inRange(ip net.IP) bool {
+	return compareIPv6(ip, s.ipFrom) >= 0 && compareIPv6(ip, s.ipTo) <= 0
+}
+
+func (s *Subnet6) incrementCurrentIP() {
+	if s.currentIP == nil || compareIPv6(s.currentIP, s.ipTo) >= 0 {
+		s.currentIP = s.ipFrom
+		return
+	}
+	s.currentIP = nextIPv6(s.currentIP)
+}
+
+func (s *Subnet6) AddLease(l *Lease) {
+	ip := l.addrKey()
+	old, ok := s.leaseCache[ip]
+	if ok {
+		delete(s.leaseCache, old.Key())
+	}
+	s.leaseCache[ip] = l
+	s.leaseCache[l.Key()] = l
+}
+
+func (s *Subnet6) AddHost(h Host) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	lease := Lease{
+		Subnet:    s.Subnet,
+		MAC:       h.MAC,
+		DUID:      h.DUID,
+		DNS:       h.DNS,
+		LeaseTime: h.LeaseTime,
+		HostName:  h.HostName,
+		Static:    true,
+	}
+	if lease.DNS == nil {
+		lease.DNS = s.DNS
+	}
+	if lease.LeaseTime == 0 {
+		lease.LeaseTime = s.LeaseTime
+	}
+	//reservation has no IAID, so it's indexed by host key instead of lease key
+	if h.IP != nil {
+		address := lease
+		address.IP = h.IP
+		s.leaseCache[address.addrKey()] = &address
+		s.leaseCache[hostKey6(h.MAC, h.DUID)] = &address
+	}
+	if h.Prefix != nil {
+		prefix := lease
+		prefix.IP = h.Prefix.IP
+		prefix.PrefixLength, _ = h.Prefix.Mask.Size()
+		s.leaseCache[prefix.addrKey()] = &prefix
+		s.leaseCache[hostPrefixKey6(h.MAC, h.DUID)] = &prefix
+	}
+}
+
+func (s *Subnet6) DeleteHost(h Host) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	if h.IP != nil {
+		delete(s.leaseCache, hostKey6(h.MAC, h.DUID))
+		delete(s.leaseCache, h.IP.String())
+	}
+	if h.Prefix != nil {
+		delete(s.leaseCache, hostPrefixKey6(h.MAC, h.DUID))
+		delete(s.leaseCache, h.Prefix.String())
+	}
+}
+
+func hostPrefixKey6(mac string, duid string) string {
+	return "pd:" + hostKey6(mac, duid)
+}
+
+// findHost returns reservation for client's DUID or MAC. hostKey is hostKey6 or hostPrefixKey6
+func (s *Subnet6) findHost(hostKey func(mac string, duid string) string, duid string, mac string) *Lease {
+	if lease, ok := s.leaseCache[hostKey("", duid)]; ok && lease.Static {
+		return lease
+	}
+	if mac == "" {
+		return nil
+	}
+	if lease, ok := s.leaseCache[hostKey(mac, "")]; ok && lease.Static {
+		return lease
+	}
+	return nil
+}
+
+// getStaticLease returns copy of client's reservation bound to the IA
+func (s *Subnet6) getStaticLease(hostKey func(mac string, duid string) string, ia iaRequest) *Lease {
+	host := s.findHost(hostKey, ia.DUID, ia.MAC)
+	if host == nil {
+		return nil
+	}
+	hostLease := *host
+	hostLease.DUID = ia.DUID
+	hostLease.IAID = ia.IAID
+	hostLease.PeerAddress = ia.PeerAddress
+	hostLease.LastUpdate = time.Now()
+	return &hostLease
+}
+
+// getBinding returns existing binding of IA, refreshed if client commits it
+func (s *Subnet6) getBinding(key string, ia iaRequest) (*Lease, bool) {
+	lease, ok := s.leaseCache[key]
+	if !ok {
+		return nil, false
+	}
+	if lease.Released || ia.Commit {
+		lease.Released = false
+		lease.LastUpdate = time.Now()
+	}
+	if ia.PeerAddress != nil {
+		lease.PeerAddress = ia.PeerAddress
+	}
+	return lease, true
+}
+
+// GetLeaseForIA returns copy of address binding of client's IA, errNoBinding if there is no binding and
+// allocation is not allowed, or *NakError if pool is exhausted
+func (s *Subnet6) GetLeaseForIA(ia iaRequest) (*Lease, error) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	lease, err := s.getLeaseForIA(ia)
+	if err != nil {
+		return nil, err
+	}
+	return commitBinding(lease, ia), nil
+}
+
+// GetPrefixForIA returns copy of delegated prefix binding of client's IA_PD, errNoBinding if there is no binding
+// and allocation is not allowed, or *NakError if pool is exhausted
+func (s *Subnet6) GetPrefixForIA(ia iaRequest) (*Lease, error) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	lease, err := s.getPrefixForIA(ia)
+	if err != nil {
+		return nil, err
+	}
+	return commitBinding(lease, ia), nil
+}
+
+// commitBinding marks binding acknowledged if client commits it, and returns its copy. Cache must be locked
+func commitBinding(lease *Lease, ia iaRequest) *Lease {
+	lease.AckSent = ia.Commit
+	l := *lease
+	return &l
+}
+
+// getLeaseForIA returns cached address binding of client's IA. Cache must be locked
+func (s *Subnet6) getLeaseForIA(ia iaRequest) (*Lease, error) {
+	lease, ok := s.getBinding(leaseKey6(ia.DUID, ia.IAID), ia)
+	if ok {
+		return lease, nil
+	}
+	if host := s.getStaticLease(hostKey6, ia); host != nil {
+		return host, nil
+	}
+
+	if !ia.Allocate {
+		return nil, errNoBinding
+	}
+
+	//Address hinted by the client is given if available
+	if !isAddressZero(ia.Hint) && s.inRange(ia.Hint) {
+		lease, ok = s.leaseCache[ia.Hint.String()]
+		if !ok || lease.isReusable() {
+			lease = s.NewLease(ia, ia.Hint)
+			s.AddLease(lease)
+			return lease, nil
+		}
+	}
+
+	s.incrementCurrentIP()
+	firstIP := s.currentIP
+	for {
+		lease, ok = s.leaseCache[s.currentIP.String()]
+		if !ok || lease.isReusable() {
+			lease = s.NewLease(ia, s.currentIP)
+			s.AddLease(lease)
+			return lease, nil
+		}
+		s.incrementCurrentIP()
+		if s.currentIP.Equal(firstIP) {
+			return nil, newNakError(NakReasonPoolExhausted, "no available addresses in pool %s", s.Subnet)
+		}
+	}
+}
+
+// getPrefixForIA returns cached delegated prefix binding of client's IA_PD. Cache must be locked
+func (s *Subnet6) getPrefixForIA(ia iaRequest) (*Lease, error) {
+	lease, ok := s.getBinding(prefixKey6(ia.DUID, ia.IAID), ia)
+	if ok {
+		return lease, nil
+	}
+	if host := s.getStaticLease(hostPrefixKey6, ia); host != nil {
+		return host, nil
+	}
+	if !ia.Allocate || s.pdNet == nil {
+		return nil, errNoBinding
+	}
+
+	//Prefix hinted by the client is given if available
+	if ia.HintPrefix != nil && s.isPoolPrefix(ia.HintPrefix) {
+		lease, ok = s.leaseCache[ia.HintPrefix.String()]
+		if !ok || lease.isReusable() {
+			lease = s.NewPrefixLease(ia, ia.HintPrefix)
+			s.AddLease(lease)
+			return lease, nil
+		}
+	}
+
+	firstIndex := s.pdCurrent
+	for {
+		prefix := s.prefixAt(s.pdCurrent)
+		s.pdCurrent = (s.pdCurrent + 1) % s.pdSize
+		lease, ok = s.leaseCache[prefix.String()]
+		if !ok || lease.isReusable() {
+			lease = s.NewPrefixLease(ia, prefix)
+			s.AddLease(lease)
+			return lease, nil
+		}
+		if s.pdCurrent == firstIndex {
+			return nil, newNakError(NakReasonPoolExhausted, "no available prefixes in pool %s", s.PDPrefix)
+		}
+	}
+}
+
+func (s *Subnet6) NewLease(ia iaRequest, ip net.IP) *Lease {
+	return &Lease{
+		Subnet:      s.Subnet,
+		MAC:         ia.MAC,
+		DUID:        ia.DUID,
+		IAID:        ia.IAID,
+		PeerAddress: ia.PeerAddress,
+		IP:          ip,
+		LastUpdate:  time.Now(),
+		DNS:         s.DNS,
+		LeaseTime:   s.LeaseTime,
+	}
+}
+
+func (s *Subnet6) NewPrefixLease(ia iaRequest, prefix *net.IPNet) *Lease {
+	lease := s.NewLease(ia, prefix.IP)
+	lease.PrefixLength, _ = prefix.Mask.Size()
+	return lease
+}
+
+// ReleaseLease marks address binding of client's IA as released. Returned lease is a copy to be saved.
+func (s *Subnet6) ReleaseLease(duid string, iaid uint32, ip net.IP) (*Lease, error) {
+	return s.release(leaseKey6(duid, iaid), hostKey6, duid, iaid, ip.String())
+}
+
+// ReleasePrefix marks delegated prefix binding of client's IA as released. Returned lease is a copy to be saved.
+func (s *Subnet6) ReleasePrefix(duid string, iaid uint32, prefix *net.IPNet) (*Lease, error) {
+	return s.release(prefixKey6(duid, iaid), hostPrefixKey6, duid, iaid, prefix.String())
+}
+
+func (s *Subnet6) release(key string, hostKey func(mac string, duid string) string,
+	duid string, iaid uint32, addr string) (*Lease, error) {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	lease, ok := s.leaseCache[key]
+	if !ok {
+		if host := s.findHost(hostKey, duid, ""); host != nil && host.addrKey() == addr {
+			released := *host
+			released.IAID = iaid
+			released.Released = true
+			return &released, nil
+		}
+		return nil, errNoBinding
+	}
+	if lease.addrKey() != addr {
+		return nil, fmt.Errorf("client %s released %s but holds lease for %s", duid, addr, lease.addrKey())
+	}
+	lease.Released = true
+	lease.AckSent = false
+	lease.LastUpdate = time.Now()
+	released := *lease
+	return &released, nil
+}
+
+// ReapExpiredLeases removes expired and released bindings from cache.
+// Copies of removed leases which should be deleted from status are returned.
+func (s *Subnet6) ReapExpiredLeases() []*Lease {
+	s.leaseCacheMutex.Lock()
+	defer s.leaseCacheMutex.Unlock()
+	var expired []*Lease
+	for key, lease := range s.leaseCache {
+		//each binding is indexed by both address (or prefix) and lease key, so visit it once
+		if key != lease.addrKey() || !lease.isReusable() {
+			continue
+		}
+		delete(s.leaseCache, key)
+		if l, ok := s.leaseCache[lease.Key()]; ok && l == lease {
+			delete(s.leaseCache, lease.Key())
+		}
+		if lease.Released {
+			//already removed from status
+			continue
+		}
+		e := *lease
+		e.Expired = true
+		expired = append(expired, &e)
+	}
+	return expired
+}
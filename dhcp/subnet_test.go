@@ -1,9 +1,12 @@
 package dhcp
 
 import (
+	"errors"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"log"
+	"net"
 	"testing"
+	"time"
 )
 
 func assertTrue(t *testing.T, b bool) {
@@ -29,14 +32,236 @@ func TestSubnet_GetLeaseForMAC(t *testing.T) {
 	s := &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.3"}
 	err := InitializeSubnet(s, LocalIPAddresses{})
 	assertNoError(t, err)
-	l1 := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 01}})
+	l1, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 01}})
+	assertNoError(t, err)
 	assertEqual(t, l1.IP.String(), "10.1.1.1")
-	l2 := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 02}})
+	l2, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 02}})
+	assertNoError(t, err)
 	assertEqual(t, l2.IP.String(), "10.1.1.2")
-	l3 := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 03}})
+	l3, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 03}})
+	assertNoError(t, err)
 	assertEqual(t, l3.IP.String(), "10.1.1.3")
-	l3 = s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 03}})
+	l3, err = s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 03}})
+	assertNoError(t, err)
 	assertEqual(t, l3.IP.String(), "10.1.1.3")
-	l4 := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 04}})
+	l4, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: []byte{00, 00, 00, 00, 00, 04}})
 	assertTrue(t, l4 == nil)
+	assertNakReason(t, err, NakReasonPoolExhausted)
+}
+
+func assertNakReason(t *testing.T, err error, reason NakReason) {
+	var nakErr *NakError
+	if !errors.As(err, &nakErr) {
+		t.Fatalf("expected NAK error, got: %v", err)
+	}
+	assertEqual(t, reason, nakErr.Reason)
+}
+
+func newTestRequest(mac net.HardwareAddr, requested net.IP) *dhcpv4.DHCPv4 {
+	req := &dhcpv4.DHCPv4{ClientHWAddr: mac}
+	req.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+	if requested != nil {
+		req.UpdateOption(dhcpv4.OptRequestedIPAddress(requested))
+	}
+	return req
+}
+
+func TestSubnet_GetLeaseForRequest_Nak(t *testing.T) {
+	s := &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.3"}
+	err := InitializeSubnet(s, LocalIPAddresses{})
+	assertNoError(t, err)
+	mac1 := net.HardwareAddr{00, 00, 00, 00, 00, 01}
+	mac2 := net.HardwareAddr{00, 00, 00, 00, 00, 02}
+
+	l1, err := s.GetLeaseForRequest(newTestRequest(mac1, net.ParseIP("10.1.1.2")))
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.2", l1.IP.String())
+
+	_, err = s.GetLeaseForRequest(newTestRequest(mac1, net.ParseIP("10.1.1.3")))
+	assertNakReason(t, err, NakReasonAddressMismatch)
+
+	_, err = s.GetLeaseForRequest(newTestRequest(mac2, net.ParseIP("10.1.1.2")))
+	assertNakReason(t, err, NakReasonAddressNotAvailable)
+
+	_, err = s.GetLeaseForRequest(newTestRequest(mac2, net.ParseIP("10.1.1.100")))
+	assertNakReason(t, err, NakReasonAddressNotAvailable)
+
+	_, err = s.GetLeaseForRequest(newTestRequest(mac2, net.ParseIP("10.2.2.2")))
+	assertNakReason(t, err, NakReasonWrongSubnet)
+
+	//requested address in DISCOVER is only a hint
+	discover := newTestRequest(mac2, net.ParseIP("10.2.2.2"))
+	discover.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeDiscover))
+	l2, err := s.GetLeaseForRequest(discover)
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.1", l2.IP.String())
+}
+
+func TestSubnet_ReleaseLease(t *testing.T) {
+	s := &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.2"}
+	err := InitializeSubnet(s, LocalIPAddresses{})
+	assertNoError(t, err)
+	mac1 := net.HardwareAddr{00, 00, 00, 00, 00, 01}
+	mac2 := net.HardwareAddr{00, 00, 00, 00, 00, 02}
+	mac3 := net.HardwareAddr{00, 00, 00, 00, 00, 03}
+	s.AddHost(Host{MAC: mac2.String(), IP: net.ParseIP("10.1.1.2")})
+
+	l1, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac1})
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.1", l1.IP.String())
+
+	_, err = s.ReleaseLease(&dhcpv4.DHCPv4{ClientHWAddr: mac1}, net.ParseIP("10.1.1.2"))
+	assertTrue(t, err != nil)
+	released, err := s.ReleaseLease(&dhcpv4.DHCPv4{ClientHWAddr: mac1}, net.ParseIP("10.1.1.1"))
+	assertNoError(t, err)
+	assertTrue(t, released.Released)
+
+	_, err = s.ReleaseLease(&dhcpv4.DHCPv4{ClientHWAddr: mac2}, net.ParseIP("10.1.1.2"))
+	assertNoError(t, err)
+
+	//released dynamic address is reused, released static one is not
+	l3, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac3})
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.1", l3.IP.String())
+	_, ok := s.leaseCache[mac1.String()]
+	assertTrue(t, !ok)
+
+	l2, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac2})
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.2", l2.IP.String())
+	assertTrue(t, !l2.Released)
+}
+
+func TestSubnet_DeclineLease(t *testing.T) {
+	s := &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.2", DeclineQuarantineTime: 60}
+	err := InitializeSubnet(s, LocalIPAddresses{})
+	assertNoError(t, err)
+	mac1 := net.HardwareAddr{00, 00, 00, 00, 00, 01}
+	mac2 := net.HardwareAddr{00, 00, 00, 00, 00, 02}
+
+	l1, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac1})
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.1", l1.IP.String())
+
+	declined, err := s.DeclineLease(&dhcpv4.DHCPv4{ClientHWAddr: mac1}, net.ParseIP("10.1.1.1"))
+	assertNoError(t, err)
+	assertTrue(t, declined.Declined)
+	assertTrue(t, declined.QuarantinedUntil.After(declined.LastUpdate))
+
+	//declining client gets the next address, quarantined one is skipped
+	l1, err = s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac1})
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.2", l1.IP.String())
+	_, err = s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac2})
+	assertNakReason(t, err, NakReasonPoolExhausted)
+	_, err = s.GetLeaseForRequest(newTestRequest(mac2, net.ParseIP("10.1.1.1")))
+	assertNakReason(t, err, NakReasonAddressNotAvailable)
+
+	//address is reused when quarantine is over
+	s.leaseCache["10.1.1.1"].QuarantinedUntil = time.Now().Add(-time.Second)
+	l2, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac2})
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.1", l2.IP.String())
+}
+
+func TestSubnet_ReapExpiredLeases(t *testing.T) {
+	s := &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.3", LeaseTime: 60}
+	err := InitializeSubnet(s, LocalIPAddresses{})
+	assertNoError(t, err)
+	mac1 := net.HardwareAddr{00, 00, 00, 00, 00, 01}
+	mac2 := net.HardwareAddr{00, 00, 00, 00, 00, 02}
+	mac3 := net.HardwareAddr{00, 00, 00, 00, 00, 03}
+	s.AddHost(Host{MAC: mac3.String(), IP: net.ParseIP("10.1.1.3")})
+
+	l1, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac1})
+	assertNoError(t, err)
+	l2, err := s.GetLeaseForRequest(&dhcpv4.DHCPv4{ClientHWAddr: mac2})
+	assertNoError(t, err)
+	assertTrue(t, !l1.IsExpired())
+	assertEqual(t, 0, len(s.ReapExpiredLeases()))
+
+	//returned leases are copies, age cached ones
+	l1, l2 = s.leaseCache[mac1.String()], s.leaseCache[mac2.String()]
+	l1.LastUpdate = time.Now().Add(-time.Second*60 - leaseExpiryGracePeriod - time.Second)
+	l2.LastUpdate = time.Now().Add(-time.Second * 60)
+	assertTrue(t, l1.IsExpired())
+	assertTrue(t, !l2.IsExpired())
+	assertTrue(t, !s.leaseCache[mac3.String()].IsExpired())
+
+	expired := s.ReapExpiredLeases()
+	assertEqual(t, 1, len(expired))
+	assertEqual(t, "10.1.1.1", expired[0].IP.String())
+	assertTrue(t, expired[0].Expired)
+	_, ok := s.leaseCache[mac1.String()]
+	assertTrue(t, !ok)
+	_, ok = s.leaseCache["10.1.1.1"]
+	assertTrue(t, !ok)
+	_, ok = s.leaseCache[mac2.String()]
+	assertTrue(t, ok)
+	_, ok = s.leaseCache[mac3.String()]
+	assertTrue(t, ok)
+}
+
+func newClientIDRequest(mac net.HardwareAddr, clientID []byte) *dhcpv4.DHCPv4 {
+	req := &dhcpv4.DHCPv4{ClientHWAddr: mac, Options: dhcpv4.Options{}}
+	if clientID != nil {
+		req.UpdateOption(dhcpv4.OptClientIdentifier(clientID))
+	}
+	return req
+}
+
+func TestSubnet_ClientIDPolicy(t *testing.T) {
+	mac1 := net.HardwareAddr{00, 00, 00, 00, 00, 01}
+	mac2 := net.HardwareAddr{00, 00, 00, 00, 00, 02}
+	clientID := []byte{0xff, 0x01, 0x02, 0x03}
+
+	s := &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.10", ClientIDPolicy: "invalid"}
+	assertTrue(t, InitializeSubnet(s, LocalIPAddresses{}) != nil)
+
+	//mac policy ignores client id
+	s = &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.10"}
+	assertNoError(t, InitializeSubnet(s, LocalIPAddresses{}))
+	l1, err := s.GetLeaseForRequest(newClientIDRequest(mac1, clientID))
+	assertNoError(t, err)
+	assertEqual(t, "", l1.ClientID)
+	l2, err := s.GetLeaseForRequest(newClientIDRequest(mac2, clientID))
+	assertNoError(t, err)
+	assertTrue(t, !l1.IP.Equal(l2.IP))
+
+	//client id policy binds lease to client id, chaddr may change
+	s = &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.10", ClientIDPolicy: ClientIDPolicyClientID}
+	assertNoError(t, InitializeSubnet(s, LocalIPAddresses{}))
+	l0, err := s.GetLeaseForRequest(newClientIDRequest(mac1, nil))
+	assertNoError(t, err)
+	l1, err = s.GetLeaseForRequest(newClientIDRequest(mac1, clientID))
+	assertNoError(t, err)
+	assertEqual(t, "ff:01:02:03", l1.ClientID)
+	assertEqual(t, "id:ff:01:02:03", l1.Key())
+	assertTrue(t, !l0.IP.Equal(l1.IP))
+	l2, err = s.GetLeaseForRequest(newClientIDRequest(mac2, clientID))
+	assertNoError(t, err)
+	assertEqual(t, l1.IP.String(), l2.IP.String())
+	released, err := s.ReleaseLease(newClientIDRequest(mac2, clientID), l1.IP)
+	assertNoError(t, err)
+	assertEqual(t, "id:ff:01:02:03", released.Key())
+
+	//lease bound to mac at PXE stage is taken over by client id
+	s = &Subnet{Subnet: "10.1.1.0/24", RangeFrom: "10.1.1.1", RangeTo: "10.1.1.10", ClientIDPolicy: ClientIDPolicyClientIDWithMACFallback}
+	assertNoError(t, InitializeSubnet(s, LocalIPAddresses{}))
+	l0, err = s.GetLeaseForRequest(newClientIDRequest(mac1, nil))
+	assertNoError(t, err)
+	l1, err = s.GetLeaseForRequest(newClientIDRequest(mac1, clientID))
+	assertNoError(t, err)
+	assertEqual(t, l0.IP.String(), l1.IP.String())
+	assertEqual(t, "ff:01:02:03", l1.ClientID)
+	_, ok := s.leaseCache[mac1.String()]
+	assertTrue(t, !ok)
+	assertTrue(t, s.leaseCache["id:ff:01:02:03"].IP.Equal(l1.IP))
+
+	//reservations are keyed by client id
+	s.AddHost(Host{MAC: mac2.String(), ClientID: "ff:09:09", IP: net.ParseIP("10.1.1.9")})
+	l2, err = s.GetLeaseForRequest(newClientIDRequest(mac1, []byte{0xff, 0x09, 0x09}))
+	assertNoError(t, err)
+	assertEqual(t, "10.1.1.9", l2.IP.String())
+	assertTrue(t, l2.Static)
 }
@@ -1,8 +1,10 @@
 package dhcp
 
 import (
+	"fmt"
 	"net"
 	"strings"
+	"time"
 )
 
 // GetLocalAddresses return map
@@ -21,9 +23,6 @@ func GetLocalAddresses() (LocalIPAddresses, error) {
 		for _, addr := range addrs {
 			_ifs := rv[interfaceName(i.Name)]
 			_addrs := strings.Split(addr.String(), "/")
-			if strings.Contains(_addrs[0], ":") {
-				continue
-			}
 			rv[interfaceName(i.Name)] = append(_ifs, net.ParseIP(_addrs[0]))
 		}
 	}
@@ -38,11 +37,80 @@ func isOverlap(s1 *Subnet, s2 *Subnet) bool {
 	return false
 }
 
+// IsExpired returns true if lease time and grace period passed since last update.
+// Static leases never expire.
 func (l Lease) IsExpired() bool {
-	//TODO
-	return false
+	if l.Static {
+		return false
+	}
+	lifetime := time.Second*time.Duration(l.LeaseTime) + leaseExpiryGracePeriod
+	return time.Now().After(l.LastUpdate.Add(lifetime))
+}
+
+// isReusable returns true if address of the lease may be given to another client
+func (l Lease) isReusable() bool {
+	if l.Static {
+		return false
+	}
+	if l.Declined {
+		return !l.isQuarantined()
+	}
+	return l.Released || l.IsExpired()
+}
+
+// isQuarantined returns true if address was declined by client and must not be offered yet
+func (l Lease) isQuarantined() bool {
+	return l.Declined && time.Now().Before(l.QuarantinedUntil)
 }
 
 func isAddressZero(ip net.IP) bool {
 	return ip == nil || ip.Equal(net.IPv4zero)
 }
+
+// Key returns lease cache key of the client: DUID and IAID for DHCPv6 bindings,
+// client id if lease is bound to it, MAC otherwise
+func (l Lease) Key() string {
+	if l.DUID != "" && l.PrefixLength != 0 {
+		return prefixKey6(l.DUID, l.IAID)
+	}
+	if l.DUID != "" {
+		return leaseKey6(l.DUID, l.IAID)
+	}
+	return leaseKey(l.MAC, l.ClientID)
+}
+
+// addrKey returns lease cache key of leased address: ip, or prefix for delegated prefixes
+func (l Lease) addrKey() string {
+	if l.PrefixLength != 0 {
+		return fmt.Sprintf("%s/%d", l.IP, l.PrefixLength)
+	}
+	return l.IP.String()
+}
+
+func leaseKey(mac string, clientID string) string {
+	if clientID != "" {
+		return "id:" + clientID
+	}
+	return mac
+}
+
+// formatHex returns bytes as colon separated hex, like hardware addresses are formatted
+func formatHex(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// ParseClientID normalizes client id or DUID given as hex string (with or without colons)
+func ParseClientID(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	data, err := encodeOptionValue(OptionTypeHex, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid client id %q: %w", value, err)
+	}
+	return formatHex(data), nil
+}
@@ -0,0 +1,151 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"strings"
+)
+
+// VendorOptions are sub-options encapsulated in vendor specific information (option 43). They are sent to clients
+// which vendor class identifier (option 60) starts with VendorClass, to any client if VendorClass is empty.
+// Option ID is sub-option code
+type VendorOptions struct {
+	VendorClass string
+	Options     []Option
+}
+
+// EnterpriseOptions are sub-options of vendor-identifying vendor-specific information (option 125, RFC 3925).
+// They are sent to clients which sent the enterprise number in option 124 or 125
+type EnterpriseOptions struct {
+	EnterpriseNumber uint32
+	Options          []Option
+}
+
+func validateSubOptions(opts []Option) error {
+	for _, opt := range opts {
+		if opt.ID == 0 || opt.ID == 255 {
+			return fmt.Errorf("invalid sub-option code %d", opt.ID)
+		}
+	}
+	if err := ValidateOptions(opts); err != nil {
+		return err
+	}
+	_, err := encodeSubOptions(opts)
+	return err
+}
+
+// ValidateVendorOptions returns first invalid vendor (option 43) or enterprise (option 125) sub-option error
+func ValidateVendorOptions(vendorOptions []VendorOptions, enterpriseOptions []EnterpriseOptions) error {
+	for _, v := range vendorOptions {
+		if err := validateSubOptions(v.Options); err != nil {
+			return fmt.Errorf("vendor class %q: %w", v.VendorClass, err)
+		}
+	}
+	for _, e := range enterpriseOptions {
+		if err := validateSubOptions(e.Options); err != nil {
+			return fmt.Errorf("enterprise number %d: %w", e.EnterpriseNumber, err)
+		}
+	}
+	return nil
+}
+
+// encodeSubOptions returns sub-options in code, length, value format. Values of options with
+// expressions are not known until request, so they are skipped
+func encodeSubOptions(opts []Option) ([]byte, error) {
+	var data []byte
+	for _, opt := range opts {
+		if opt.Expression != nil {
+			continue
+		}
+		value, err := opt.Encode()
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > 255 {
+			return nil, fmt.Errorf("sub-option %d is longer than 255 bytes", opt.ID)
+		}
+		data = append(data, opt.ID, uint8(len(value)))
+		data = append(data, value...)
+	}
+	if len(data) > 255 {
+		return nil, fmt.Errorf("sub-options are longer than 255 bytes")
+	}
+	return data, nil
+}
+
+// requestEnterpriseNumbers returns enterprise numbers of vendor-identifying options (124, 125) of the request
+func requestEnterpriseNumbers(req *dhcpv4.DHCPv4) map[uint32]bool {
+	numbers := map[uint32]bool{}
+	for _, code := range []dhcpv4.OptionCode{
+		dhcpv4.OptionVendorIdentifyingVendorClass,
+		dhcpv4.OptionVendorIdentifyingVendorSpecific,
+	} {
+		var ids dhcpv4.VIVCIdentifiers
+		if err := ids.FromBytes(req.Options.Get(code)); err != nil {
+			continue
+		}
+		for _, id := range ids {
+			numbers[uint32(id.EntID)] = true
+		}
+	}
+	return numbers
+}
+
+// vendorSpaces returns vendor and enterprise options of the subnet and matched classes.
+// Options of classes go first by priority, so they take precedence over subnet ones
+func vendorSpaces(subnet *Subnet, classes []*ClientClass) ([]VendorOptions, []EnterpriseOptions) {
+	var (
+		vendorOptions     []VendorOptions
+		enterpriseOptions []EnterpriseOptions
+	)
+	for _, class := range classes {
+		vendorOptions = append(vendorOptions, class.VendorOptions...)
+		enterpriseOptions = append(enterpriseOptions, class.EnterpriseOptions...)
+	}
+	vendorOptions = append(vendorOptions, subnet.VendorOptions...)
+	enterpriseOptions = append(enterpriseOptions, subnet.EnterpriseOptions...)
+	return vendorOptions, enterpriseOptions
+}
+
+// setVendorOptions sets option 43 from the first vendor options matching client's vendor class, and option 125
+// from enterprise options for enterprise numbers sent by client. They are sent regardless of parameter request list
+func (s *Server) setVendorOptions(req Request, resp *dhcpv4.DHCPv4, subnet *Subnet, classes []*ClientClass) {
+	vendorOptions, enterpriseOptions := vendorSpaces(subnet, classes)
+	vendorClass := req.ClassIdentifier()
+	for _, v := range vendorOptions {
+		if !strings.HasPrefix(vendorClass, v.VendorClass) {
+			continue
+		}
+		data, err := encodeSubOptions(s.evaluateOptions(req, v.Options))
+		if err != nil {
+			s.log.Errorf(err, "skipping vendor options %q for %s", v.VendorClass, req.ClientHWAddr)
+			break
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, data))
+		break
+	}
+
+	numbers := requestEnterpriseNumbers(req.DHCPv4)
+	var vivso []byte
+	for _, e := range enterpriseOptions {
+		if !numbers[e.EnterpriseNumber] {
+			continue
+		}
+		//first options of the enterprise number take precedence
+		delete(numbers, e.EnterpriseNumber)
+		data, err := encodeSubOptions(s.evaluateOptions(req, e.Options))
+		if err != nil {
+			s.log.Errorf(err, "skipping enterprise options %d for %s", e.EnterpriseNumber, req.ClientHWAddr)
+			continue
+		}
+		header := make([]byte, 5)
+		binary.BigEndian.PutUint32(header, e.EnterpriseNumber)
+		header[4] = uint8(len(data))
+		vivso = append(vivso, header...)
+		vivso = append(vivso, data...)
+	}
+	if len(vivso) > 0 {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, vivso))
+	}
+}
@@ -0,0 +1,204 @@
+package httpboot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/bmcgo/k8s-dhcp/dhcp"
+)
+
+// BootPath is URL path boot artifacts other than iPXE scripts are served at, followed by template name
+const BootPath = "/boot/"
+
+// LeaseFinder returns the current lease of the hardware address at the address, or nil
+type LeaseFinder interface {
+	FindLease(mac string, ip net.IP) *dhcp.Lease
+}
+
+type Config struct {
+	Addr   string //listen address, e.g. ":8080"
+	Leases LeaseFinder
+	Logger dhcp.RLogger
+}
+
+// TemplateData is passed to templates. Lease fields are empty if client has no lease
+type TemplateData struct {
+	MAC       string
+	IP        string
+	HostName  string
+	Subnet    string
+	NetMask   string
+	Gateway   string
+	DNS       []string
+	Arch      string            //client architecture, e.g. "efi-x64"
+	Params    map[string]string //boot parameters of the host and subnet
+	ServerURL string            //URL of this endpoint, e.g. "http://10.0.0.1:8080", to link other artifacts
+}
+
+// Server renders iPXE scripts and other boot artifacts for clients from templates, with data of client lease.
+// Scripts are served at /ipxe/<name> and other artifacts at /boot/<name>, client is selected by mac parameter,
+// e.g. /ipxe/boot.ipxe?mac=52:54:00:12:34:56&arch=efi-x64. Lease data is rendered only for requests coming
+// from the leased address
+type Server struct {
+	httpServer *http.Server
+	leases     LeaseFinder
+	templates  map[string]map[string]*template.Template //by source, then by name
+
+	mutex sync.RWMutex
+	log   dhcp.RLogger
+}
+
+func NewServer(c Config) (*Server, error) {
+	if c.Logger == nil {
+		return nil, errors.New("no logger set")
+	}
+	if c.Leases == nil {
+		return nil, errors.New("no lease finder set")
+	}
+	s := &Server{
+		leases:    c.Leases,
+		templates: map[string]map[string]*template.Template{},
+		log:       c.Logger.WithName(fmt.Sprintf("httpboot[%s]", c.Addr)),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(dhcp.IPXEScriptPath, s.serveTemplate)
+	mux.HandleFunc(BootPath, s.serveTemplate)
+	s.httpServer = &http.Server{Addr: c.Addr, Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	return s, nil
+}
+
+// Start serves requests until context is done, so server can be run by controller manager
+func (s *Server) Start(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+			s.log.Errorf(err, "failed to shutdown HTTP boot server")
+		}
+	}()
+	s.log.Infof("Serving HTTP boot at %s", s.httpServer.Addr)
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// SetTemplates replaces templates of the source. All templates are parsed first, and nothing is replaced
+// if any of them is invalid
+func (s *Server) SetTemplates(source string, texts map[string]string) error {
+	templates := make(map[string]*template.Template, len(texts))
+	for name, text := range texts {
+		t, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template %q: %w", name, err)
+		}
+		templates[name] = t
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.templates[source] = templates
+	return nil
+}
+
+// DeleteTemplates removes templates of the source
+func (s *Server) DeleteTemplates(source string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.templates, source)
+}
+
+// template returns template by name. If several sources have the same template, source which name is
+// the first in sort order wins
+func (s *Server) template(name string) *template.Template {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sources := make([]string, 0, len(s.templates))
+	for source := range s.templates {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if t, ok := s.templates[source][name]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, dhcp.IPXEScriptPath), BootPath)
+	t := s.template(name)
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := s.templateData(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		s.log.Errorf(err, "failed to render %q for %s", name, data.MAC)
+		http.Error(w, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+	s.log.Infof("Serving %q to %s (%s)", name, data.MAC, r.RemoteAddr)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// templateData returns data of the client selected by mac parameter and remote address
+func (s *Server) templateData(r *http.Request) (*TemplateData, error) {
+	query := r.URL.Query()
+	data := &TemplateData{
+		Arch:      query.Get("arch"),
+		ServerURL: "http://" + r.Host,
+	}
+	if query.Get("mac") == "" {
+		return data, nil
+	}
+	mac, err := net.ParseMAC(query.Get("mac"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mac %q", query.Get("mac"))
+	}
+	data.MAC = mac.String()
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	lease := s.leases.FindLease(data.MAC, ip)
+	if lease == nil {
+		s.log.Infof("No lease for %s at %s", data.MAC, ip)
+		return data, nil
+	}
+	data.IP = lease.IP.String()
+	data.HostName = lease.HostName
+	data.Subnet = string(lease.Subnet)
+	data.NetMask = lease.NetMask
+	if lease.Gateway != nil {
+		data.Gateway = lease.Gateway.String()
+	}
+	data.DNS = lease.DNS
+	data.Params = lease.BootParams
+	return data, nil
+}
@@ -0,0 +1,89 @@
+package httpboot
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmcgo/k8s-dhcp/dhcp"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLeases map[string]*dhcp.Lease
+
+func (f fakeLeases) FindLease(mac string, ip net.IP) *dhcp.Lease {
+	if l, ok := f[mac]; ok && l.IP.Equal(ip) {
+		return l
+	}
+	return nil
+}
+
+func get(t *testing.T, s *Server, url string) (int, string) {
+	return getFrom(t, s, url, "10.0.0.10:12345")
+}
+
+func getFrom(t *testing.T, s *Server, url string, remoteAddr string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Host = "10.0.0.1:8080"
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(w, req)
+	body, err := io.ReadAll(w.Result().Body)
+	require.NoError(t, err)
+	return w.Code, string(body)
+}
+
+func TestServer(t *testing.T) {
+	leases := fakeLeases{"52:54:00:12:34:56": {
+		IP:         net.ParseIP("10.0.0.10"),
+		HostName:   "node1",
+		Subnet:     "10.0.0.0/24",
+		NetMask:    "255.255.255.0",
+		Gateway:    net.ParseIP("10.0.0.1"),
+		DNS:        []string{"10.0.0.2"},
+		BootParams: map[string]string{"console": "ttyS0"},
+	}}
+	s, err := NewServer(Config{Addr: ":0", Leases: leases, Logger: &dhcp.GenericLogger{}})
+	require.NoError(t, err)
+	require.Error(t, s.SetTemplates("default/broken", map[string]string{"boot.ipxe": "{{ .IP"}))
+	require.NoError(t, s.SetTemplates("default/boot", map[string]string{
+		"boot.ipxe": "#!ipxe\nkernel {{ .ServerURL }}/boot/vmlinuz ip={{ .IP }}::{{ .Gateway }}:{{ .NetMask }}:" +
+			"{{ .HostName }} console={{ .Params.console }} arch={{ .Arch }}{{ .Params.missing }}\nboot\n",
+		"ks.cfg": "network --hostname={{ .HostName }} --nameserver={{ index .DNS 0 }}",
+	}))
+	require.NoError(t, s.SetTemplates("other/boot", map[string]string{"boot.ipxe": "#!ipxe\nexit\n"}))
+
+	code, body := get(t, s, "/ipxe/boot.ipxe?mac=52%3A54%3A00%3A12%3A34%3A56&arch=efi-x64")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "#!ipxe\nkernel http://10.0.0.1:8080/boot/vmlinuz ip=10.0.0.10::10.0.0.1:255.255.255.0:"+
+		"node1 console=ttyS0 arch=efi-x64\nboot\n", body)
+
+	code, body = get(t, s, "/boot/ks.cfg?mac=52-54-00-12-34-56")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "network --hostname=node1 --nameserver=10.0.0.2", body)
+
+	//client without lease gets template rendered with empty lease fields
+	code, body = get(t, s, "/ipxe/boot.ipxe?mac=52:54:00:00:00:01")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, " ip=::")
+
+	//lease data is not given to another address
+	code, body = getFrom(t, s, "/ipxe/boot.ipxe?mac=52:54:00:12:34:56", "10.0.0.11:12345")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, " ip=::")
+	require.NotContains(t, body, "node1")
+
+	code, _ = get(t, s, "/ipxe/boot.ipxe?mac=invalid")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = get(t, s, "/ipxe/missing.ipxe")
+	require.Equal(t, http.StatusNotFound, code)
+
+	s.DeleteTemplates("default/boot")
+	code, body = get(t, s, "/ipxe/boot.ipxe?mac=52:54:00:12:34:56")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "#!ipxe\nexit\n", body)
+	code, _ = get(t, s, "/boot/ks.cfg")
+	require.Equal(t, http.StatusNotFound, code)
+}
@@ -19,7 +19,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 
 	"github.com/go-logr/logr"
 
@@ -30,6 +32,8 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"github.com/bmcgo/k8s-dhcp/dhcp"
+	"github.com/bmcgo/k8s-dhcp/httpboot"
+	"github.com/bmcgo/k8s-dhcp/tftp"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -37,6 +41,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	dhcpv1alpha1 "github.com/bmcgo/k8s-dhcp/api/v1alpha1"
 	"github.com/bmcgo/k8s-dhcp/controllers"
@@ -58,8 +63,17 @@ func init() {
 func main() {
 	var metricsAddr string
 	var probeAddr string
+	var tftpAddr string
+	var tftpRoot string
+	var httpBootAddr string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8180", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8181", "The address the probe endpoint binds to.")
+	flag.StringVar(&tftpAddr, "tftp-bind-address", "", "The address the embedded TFTP server binds to, e.g. \":69\". "+
+		"TFTP server is disabled if empty.")
+	flag.StringVar(&tftpRoot, "tftp-root", "", "The directory TFTP server serves files from, "+
+		"in addition to files of ConfigMaps labeled "+controllers.TFTPLabel+"=true.")
+	flag.StringVar(&httpBootAddr, "http-boot-bind-address", "", "The address the HTTP boot endpoint serving iPXE "+
+		"scripts binds to, e.g. \":8080\". HTTP boot endpoint is disabled if empty.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -102,6 +116,11 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "DHCPLease")
 		os.Exit(1)
 	}
+	classReconciler := controllers.NewDHCPClientClassReconciler(mgr.GetClient(), mgr.GetScheme())
+	if err = classReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DHCPClientClass")
+		os.Exit(1)
+	}
 	if err = (&controllers.DHCPHostReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
@@ -119,19 +138,66 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	ipxeScriptPort := 0
+	if httpBootAddr != "" {
+		_, port, err := net.SplitHostPort(httpBootAddr)
+		if err == nil {
+			ipxeScriptPort, err = strconv.Atoi(port)
+		}
+		if err != nil || ipxeScriptPort == 0 {
+			setupLog.Error(err, "invalid HTTP boot address", "address", httpBootAddr)
+			os.Exit(2)
+		}
+	}
 	dhcpServer, err := dhcp.NewServer(dhcp.ServerConfig{
 		Logger:             logger,
 		CallbackSaveLeases: subnetReconciler.CallbackSaveLeases,
 		Context:            ctx,
+		IPXEScriptPort:     ipxeScriptPort,
+		EmbeddedTFTP:       tftpAddr != "",
 	})
 	if err != nil {
 		setupLog.Error(err, "failed to create server")
 		os.Exit(2)
 	}
 	defer dhcpServer.Close()
+	metrics.Registry.MustRegister(dhcp.Collectors()...)
+	if tftpAddr != "" {
+		tftpServer, err := tftp.NewServer(tftp.Config{Addr: tftpAddr, Root: tftpRoot, Logger: logger})
+		if err != nil {
+			setupLog.Error(err, "failed to create TFTP server")
+			os.Exit(2)
+		}
+		if err = mgr.Add(tftpServer); err != nil {
+			setupLog.Error(err, "unable to add TFTP server")
+			os.Exit(1)
+		}
+		tftpReconciler := controllers.NewTFTPConfigMapReconciler(mgr.GetClient(), mgr.GetScheme(), tftpServer)
+		if err = tftpReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "TFTPConfigMap")
+			os.Exit(1)
+		}
+	}
+	if httpBootAddr != "" {
+		httpBootServer, err := httpboot.NewServer(httpboot.Config{Addr: httpBootAddr, Leases: dhcpServer, Logger: logger})
+		if err != nil {
+			setupLog.Error(err, "failed to create HTTP boot server")
+			os.Exit(2)
+		}
+		if err = mgr.Add(httpBootServer); err != nil {
+			setupLog.Error(err, "unable to add HTTP boot server")
+			os.Exit(1)
+		}
+		httpBootReconciler := controllers.NewHTTPBootConfigMapReconciler(mgr.GetClient(), mgr.GetScheme(), httpBootServer)
+		if err = httpBootReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "HTTPBootConfigMap")
+			os.Exit(1)
+		}
+	}
 	serverReconciler.DHCPServer = dhcpServer
 	subnetReconciler.DHCPServer = dhcpServer
 	hostReconciler.DHCPServer = dhcpServer
+	classReconciler.DHCPServer = dhcpServer
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctx); err != nil {
@@ -0,0 +1,123 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errNotFound    = errors.New("file not found")
+	errOutsideRoot = errors.New("file is outside of root directory")
+)
+
+// Files are files served by TFTP server: files set by sources (e.g. ConfigMaps) first, then files of
+// root directory. If several sources have the same file, source which name is the first in sort order wins
+type Files struct {
+	root    string
+	sources map[string]map[string][]byte
+
+	mutex sync.RWMutex
+}
+
+func NewFiles(root string) *Files {
+	return &Files{root: root, sources: map[string]map[string][]byte{}}
+}
+
+// CleanPath returns file path relative to root, e.g. "pxelinux.cfg/default" for "/pxelinux.cfg/default".
+// Backslashes are treated as separators, and path can't point outside root
+func CleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+// Set replaces files of the source. File names are relative to root, e.g. "pxelinux.cfg/default"
+func (f *Files) Set(source string, files map[string][]byte) {
+	cleaned := make(map[string][]byte, len(files))
+	for name, data := range files {
+		cleaned[CleanPath(name)] = data
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sources[source] = cleaned
+}
+
+// Delete removes files of the source
+func (f *Files) Delete(source string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.sources, source)
+}
+
+// Get returns file of sources, or nil if there is none
+func (f *Files) Get(name string) []byte {
+	name = CleanPath(name)
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	names := make([]string, 0, len(f.sources))
+	for source := range f.sources {
+		names = append(names, source)
+	}
+	sort.Strings(names)
+	for _, source := range names {
+		if data, ok := f.sources[source][name]; ok {
+			return data
+		}
+	}
+	return nil
+}
+
+// Open returns file and its size. Error wraps errNotFound if there is no such file
+func (f *Files) Open(name string) (io.ReadCloser, int64, error) {
+	if data := f.Get(name); data != nil {
+		return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+	}
+	if f.root == "" {
+		return nil, 0, errNotFound
+	}
+	resolved, err := f.resolve(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, errNotFound
+		}
+		return nil, 0, err
+	}
+	file, err := os.Open(resolved)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, errNotFound
+		}
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = errNotFound
+	}
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// resolve returns path of the file in root directory with symlinks resolved, so they can't point outside root
+func (f *Files) resolve(name string) (string, error) {
+	root, err := filepath.EvalSymlinks(f.root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(CleanPath(name))))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return resolved, nil
+}
@@ -0,0 +1,396 @@
+package tftp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bmcgo/k8s-dhcp/dhcp"
+	"golang.org/x/net/ipv4"
+)
+
+// Opcodes (RFC 1350, RFC 2347)
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+)
+
+// Error codes (RFC 1350, RFC 2347)
+const (
+	errNotDefined       = 0
+	errFileNotFound     = 1
+	errAccessViolation  = 2
+	errIllegalOperation = 4
+	errUnknownTID       = 5
+)
+
+const (
+	defaultBlockSize = 512
+	minBlockSize     = 8
+	maxBlockSize     = 65464
+	defaultTimeout   = time.Second
+	maxTimeout       = 255 * time.Second
+	maxRetries       = 5
+	maxPacketSize    = maxBlockSize + 4
+
+	defaultMaxTransfers = 64
+)
+
+type Config struct {
+	Addr         string //listen address, e.g. ":69"
+	Root         string //directory files are served from, only files of ConfigMaps are served if empty
+	Timeout      time.Duration
+	MaxTransfers int //number of concurrent transfers, requests above it are dropped, so clients retry
+	Logger       dhcp.RLogger
+}
+
+// Server is read-only TFTP server (RFC 1350) with blksize (RFC 2348), timeout and tsize (RFC 2349) options.
+// Each transfer is served from its own port, as TFTP requires
+type Server struct {
+	conn      *ipv4.PacketConn
+	files     *Files
+	timeout   time.Duration
+	transfers chan struct{} //semaphore of running transfers
+	log       dhcp.RLogger
+}
+
+func NewServer(c Config) (*Server, error) {
+	if c.Logger == nil {
+		return nil, errors.New("no logger set")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.MaxTransfers == 0 {
+		c.MaxTransfers = defaultMaxTransfers
+	}
+	conn, err := net.ListenPacket("udp4", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		conn:      ipv4.NewPacketConn(conn),
+		files:     NewFiles(c.Root),
+		timeout:   c.Timeout,
+		transfers: make(chan struct{}, c.MaxTransfers),
+		log:       c.Logger.WithName(fmt.Sprintf("tftp[%s]", c.Addr)),
+	}
+	//transfers are served from the address request is received at, so clients accept replies
+	if err = s.conn.SetControlMessage(ipv4.FlagDst, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Files returns files served in addition to files of root directory
+func (s *Server) Files() *Files {
+	return s.files
+}
+
+// Addr returns address server listens at
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Start serves requests until context is done, so server can be run by controller manager
+func (s *Server) Start(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	return s.Serve()
+}
+
+// Serve serves requests until server is closed
+func (s *Server) Serve() error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, cm, src, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.log.Infof("Connection closed. Stopping TFTP server.")
+				return nil
+			}
+			s.log.Errorf(err, "Error reading packet")
+			continue
+		}
+		addr, ok := src.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		var local net.IP
+		if cm != nil && !cm.Dst.IsMulticast() && !cm.Dst.Equal(net.IPv4bcast) {
+			local = cm.Dst
+		}
+		req, err := parseRequest(buf[:n])
+		if err != nil {
+			s.log.Debugf("Invalid request from %s: %s", addr, err)
+			continue
+		}
+		select {
+		case s.transfers <- struct{}{}:
+			go func() {
+				defer func() { <-s.transfers }()
+				s.serveRequest(req, addr, local)
+			}()
+		default:
+			s.log.Infof("Dropping request for %q from %s: too many transfers", req.filename, addr)
+		}
+	}
+}
+
+func (s *Server) Close() {
+	if err := s.conn.Close(); err != nil {
+		s.log.Errorf(err, "failed to close socket")
+	}
+}
+
+// request is read (RRQ) or write (WRQ) request
+type request struct {
+	opcode   uint16
+	filename string
+	mode     string
+	options  map[string]string //option names are lower case
+}
+
+func parseRequest(data []byte) (*request, error) {
+	if len(data) < 2 {
+		return nil, errors.New("short packet")
+	}
+	req := &request{opcode: binary.BigEndian.Uint16(data), options: map[string]string{}}
+	if req.opcode != opRRQ && req.opcode != opWRQ {
+		return nil, fmt.Errorf("unexpected opcode %d", req.opcode)
+	}
+	fields := bytes.Split(data[2:], []byte{0})
+	//fields are zero terminated, so the last one is empty
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, errors.New("malformed request")
+	}
+	fields = fields[:len(fields)-1]
+	req.filename = string(fields[0])
+	req.mode = strings.ToLower(string(fields[1]))
+	for i := 2; i+1 < len(fields); i += 2 {
+		req.options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	return req, nil
+}
+
+// transfer is a file being sent to the client
+type transfer struct {
+	conn      *net.UDPConn
+	client    *net.UDPAddr
+	blockSize int
+	timeout   time.Duration
+	log       dhcp.RLogger
+}
+
+func (s *Server) serveRequest(req *request, client *net.UDPAddr, local net.IP) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: local})
+	if err != nil {
+		s.log.Errorf(err, "failed to open transfer socket for %s", client)
+		return
+	}
+	defer conn.Close()
+	t := &transfer{
+		conn:      conn,
+		client:    client,
+		blockSize: defaultBlockSize,
+		timeout:   s.timeout,
+		log:       s.log,
+	}
+	if req.opcode == opWRQ {
+		t.sendError(errAccessViolation, "server is read-only")
+		return
+	}
+	if req.mode != "octet" && req.mode != "netascii" {
+		t.sendError(errIllegalOperation, "unsupported mode "+req.mode)
+		return
+	}
+	file, size, err := s.files.Open(req.filename)
+	if err != nil {
+		s.log.Infof("Failed to open %q for %s: %s", req.filename, client, err)
+		if errors.Is(err, errNotFound) {
+			t.sendError(errFileNotFound, "file not found")
+		} else {
+			t.sendError(errAccessViolation, "access violation")
+		}
+		return
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if req.mode == "netascii" {
+		//size after conversion is unknown, so tsize is not acknowledged
+		reader, size = newNetASCIIReader(file), -1
+	}
+	s.log.Infof("Sending %q to %s", req.filename, client)
+	start := time.Now()
+	n, err := t.send(reader, t.negotiate(req.options, size))
+	if err != nil {
+		s.log.Errorf(err, "failed to send %q to %s", req.filename, client)
+		return
+	}
+	s.log.Infof("Sent %q to %s: %d bytes in %s", req.filename, client, n, time.Since(start))
+}
+
+// negotiate applies options requested by client, and returns options acknowledged by server (RFC 2347).
+// Invalid options are ignored, so client uses defaults
+func (t *transfer) negotiate(options map[string]string, size int64) []string {
+	var ack []string
+	if v, ok := options["blksize"]; ok {
+		if blockSize, err := strconv.Atoi(v); err == nil && blockSize >= minBlockSize {
+			if blockSize > maxBlockSize {
+				blockSize = maxBlockSize
+			}
+			t.blockSize = blockSize
+			ack = append(ack, "blksize", strconv.Itoa(blockSize))
+		}
+	}
+	if v, ok := options["timeout"]; ok {
+		if timeout, err := strconv.Atoi(v); err == nil && timeout >= 1 && time.Duration(timeout)*time.Second <= maxTimeout {
+			t.timeout = time.Duration(timeout) * time.Second
+			ack = append(ack, "timeout", v)
+		}
+	}
+	if _, ok := options["tsize"]; ok && size >= 0 {
+		ack = append(ack, "tsize", strconv.FormatInt(size, 10))
+	}
+	return ack
+}
+
+// send sends option acknowledgement if there are acknowledged options, then the file by blocks.
+// It returns number of bytes sent
+func (t *transfer) send(reader io.Reader, oack []string) (int64, error) {
+	if len(oack) > 0 {
+		packet := []byte{0, opOACK}
+		for _, s := range oack {
+			packet = append(append(packet, s...), 0)
+		}
+		if err := t.sendAndWaitAck(packet, 0); err != nil {
+			return 0, err
+		}
+	}
+	var sent int64
+	buf := make([]byte, t.blockSize+4)
+	binary.BigEndian.PutUint16(buf, opDATA)
+	//block number wraps to zero after 65535, so files larger than 65535 blocks can be sent
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(reader, buf[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.sendError(errNotDefined, "read error")
+			return sent, err
+		}
+		binary.BigEndian.PutUint16(buf[2:], block)
+		if err := t.sendAndWaitAck(buf[:n+4], block); err != nil {
+			return sent, err
+		}
+		sent += int64(n)
+		if n < t.blockSize {
+			//short block terminates transfer
+			return sent, nil
+		}
+	}
+}
+
+// sendAndWaitAck sends packet until client acknowledges the block. Duplicate acknowledgements of previous
+// blocks are ignored rather than answered, avoiding Sorcerer's Apprentice syndrome (RFC 1123 4.2.3.1)
+func (t *transfer) sendAndWaitAck(packet []byte, block uint16) error {
+	buf := make([]byte, maxPacketSize)
+	for retry := 0; retry < maxRetries; retry++ {
+		if _, err := t.conn.WriteToUDP(packet, t.client); err != nil {
+			return err
+		}
+		if err := t.conn.SetReadDeadline(time.Now().Add(t.timeout)); err != nil {
+			return err
+		}
+		for {
+			n, src, err := t.conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			if !src.IP.Equal(t.client.IP) || src.Port != t.client.Port {
+				t.sendErrorTo(src, errUnknownTID, "unknown transfer id")
+				continue
+			}
+			if n < 4 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(buf) {
+			case opACK:
+				if binary.BigEndian.Uint16(buf[2:]) == block {
+					return nil
+				}
+			case opERROR:
+				return fmt.Errorf("transfer aborted by client: %s", bytes.TrimRight(buf[4:n], "\x00"))
+			}
+		}
+	}
+	return fmt.Errorf("no acknowledgement of block %d", block)
+}
+
+func (t *transfer) sendError(code uint16, msg string) {
+	t.sendErrorTo(t.client, code, msg)
+}
+
+func (t *transfer) sendErrorTo(addr *net.UDPAddr, code uint16, msg string) {
+	packet := make([]byte, 4, len(msg)+5)
+	binary.BigEndian.PutUint16(packet, opERROR)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(append(packet, msg...), 0)
+	if _, err := t.conn.WriteToUDP(packet, addr); err != nil {
+		t.log.Errorf(err, "failed to send error to %s", addr)
+	}
+}
+
+// netASCIIReader converts line endings to CR LF, and CR to CR NUL (RFC 764) while file is read
+type netASCIIReader struct {
+	reader     *bufio.Reader
+	pending    byte //second byte of converted line ending not returned yet
+	hasPending bool
+}
+
+func newNetASCIIReader(reader io.Reader) *netASCIIReader {
+	return &netASCIIReader{reader: bufio.NewReader(reader)}
+}
+
+func (r *netASCIIReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if r.hasPending {
+			p[n] = r.pending
+			r.hasPending = false
+			n++
+			continue
+		}
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch b {
+		case '\n':
+			p[n], r.pending, r.hasPending = '\r', '\n', true
+		case '\r':
+			p[n], r.pending, r.hasPending = '\r', 0, true
+		default:
+			p[n] = b
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,195 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/bmcgo/k8s-dhcp/dhcp"
+	"github.com/stretchr/testify/require"
+)
+
+type testClient struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	server   *net.UDPAddr
+	transfer *net.UDPAddr
+}
+
+func newTestClient(t *testing.T, server net.Addr) *testClient {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, server: server.(*net.UDPAddr)}
+}
+
+func (c *testClient) request(opcode uint16, fields ...string) {
+	packet := []byte{0, byte(opcode)}
+	for _, f := range fields {
+		packet = append(append(packet, f...), 0)
+	}
+	_, err := c.conn.WriteToUDP(packet, c.server)
+	require.NoError(c.t, err)
+}
+
+// receive returns opcode and the rest of the packet sent by transfer
+func (c *testClient) receive() (uint16, []byte) {
+	buf := make([]byte, maxPacketSize)
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(time.Second*5)))
+	n, src, err := c.conn.ReadFromUDP(buf)
+	require.NoError(c.t, err)
+	require.NotEqual(c.t, c.server.Port, src.Port)
+	c.transfer = src
+	return binary.BigEndian.Uint16(buf), buf[2:n]
+}
+
+func (c *testClient) ack(block uint16) {
+	packet := make([]byte, 4)
+	binary.BigEndian.PutUint16(packet, opACK)
+	binary.BigEndian.PutUint16(packet[2:], block)
+	_, err := c.conn.WriteToUDP(packet, c.transfer)
+	require.NoError(c.t, err)
+}
+
+// download acknowledges data blocks until the short one and returns the file
+func (c *testClient) download(blockSize int, first []byte) []byte {
+	var data []byte
+	packet := first
+	for block := uint16(1); ; block++ {
+		require.Equal(c.t, block, binary.BigEndian.Uint16(packet))
+		data = append(data, packet[2:]...)
+		c.ack(block)
+		if len(packet)-2 < blockSize {
+			return data
+		}
+		var opcode uint16
+		opcode, packet = c.receive()
+		require.Equal(c.t, uint16(opDATA), opcode)
+	}
+}
+
+func TestServer(t *testing.T) {
+	root := t.TempDir()
+	kpxe := bytes.Repeat([]byte{0xeb}, 700)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "undionly.kpxe"), kpxe, 0644))
+	outside := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "secret")))
+	require.NoError(t, os.Symlink("undionly.kpxe", filepath.Join(root, "link.kpxe")))
+
+	s, err := NewServer(Config{Addr: "127.0.0.1:0", Root: root, Logger: &dhcp.GenericLogger{}})
+	require.NoError(t, err)
+	go s.Serve()
+	defer s.Close()
+	//the file is 10 blocks of 100 bytes exactly, so transfer is terminated by empty block
+	cfg := bytes.Repeat([]byte("0123456789"), 100)
+	s.Files().Set("default/boot", map[string][]byte{"pxelinux.cfg/default": cfg, "boot.txt": []byte("a\nb")})
+
+	c := newTestClient(t, s.Addr())
+	c.request(opRRQ, "pxelinux.cfg/default", "octet", "blksize", "100", "tsize", "0", "unknown", "1")
+	opcode, packet := c.receive()
+	require.Equal(t, uint16(opOACK), opcode)
+	require.Equal(t, []byte("blksize\x00100\x00tsize\x001000\x00"), packet)
+	c.ack(0)
+	opcode, packet = c.receive()
+	require.Equal(t, uint16(opDATA), opcode)
+	require.Equal(t, cfg, c.download(100, packet))
+
+	//no options, file of root directory
+	c = newTestClient(t, s.Addr())
+	c.request(opRRQ, "/undionly.kpxe", "octet")
+	opcode, packet = c.receive()
+	require.Equal(t, uint16(opDATA), opcode)
+	require.Equal(t, kpxe, c.download(defaultBlockSize, packet))
+
+	//symlink within root
+	c = newTestClient(t, s.Addr())
+	c.request(opRRQ, "link.kpxe", "octet")
+	opcode, packet = c.receive()
+	require.Equal(t, uint16(opDATA), opcode)
+	require.Equal(t, kpxe, c.download(defaultBlockSize, packet))
+
+	c = newTestClient(t, s.Addr())
+	c.request(opRRQ, "boot.txt", "netascii")
+	opcode, packet = c.receive()
+	require.Equal(t, uint16(opDATA), opcode)
+	require.Equal(t, []byte("a\r\nb"), c.download(defaultBlockSize, packet))
+
+	for _, tc := range []struct {
+		opcode   uint16
+		filename string
+		mode     string
+		code     uint16
+	}{
+		{opRRQ, "missing", "octet", errFileNotFound},
+		{opRRQ, "../" + filepath.Base(root) + "/undionly.kpxe", "octet", errFileNotFound},
+		{opRRQ, "pxelinux.cfg", "octet", errFileNotFound},
+		{opRRQ, "secret", "octet", errAccessViolation},
+		{opRRQ, "undionly.kpxe", "mail", errIllegalOperation},
+		{opWRQ, "undionly.kpxe", "octet", errAccessViolation},
+	} {
+		c = newTestClient(t, s.Addr())
+		c.request(tc.opcode, tc.filename, tc.mode)
+		opcode, packet = c.receive()
+		require.Equal(t, uint16(opERROR), opcode, tc.filename)
+		require.Equal(t, tc.code, binary.BigEndian.Uint16(packet), tc.filename)
+	}
+
+	s.Files().Delete("default/boot")
+	c = newTestClient(t, s.Addr())
+	c.request(opRRQ, "pxelinux.cfg/default", "octet")
+	opcode, packet = c.receive()
+	require.Equal(t, uint16(opERROR), opcode)
+	require.Equal(t, uint16(errFileNotFound), binary.BigEndian.Uint16(packet))
+}
+
+func TestServer_MaxTransfers(t *testing.T) {
+	s, err := NewServer(Config{Addr: "127.0.0.1:0", MaxTransfers: 1, Logger: &dhcp.GenericLogger{}})
+	require.NoError(t, err)
+	go s.Serve()
+	defer s.Close()
+	s.Files().Set("default/boot", map[string][]byte{"boot.txt": []byte("boot")})
+
+	c1 := newTestClient(t, s.Addr())
+	c1.request(opRRQ, "boot.txt", "octet")
+	opcode, _ := c1.receive()
+	require.Equal(t, uint16(opDATA), opcode)
+
+	//the only transfer is not acknowledged yet, so request is dropped
+	c2 := newTestClient(t, s.Addr())
+	c2.request(opRRQ, "boot.txt", "octet")
+	require.NoError(t, c2.conn.SetReadDeadline(time.Now().Add(time.Millisecond*200)))
+	_, _, err = c2.conn.ReadFromUDP(make([]byte, maxPacketSize))
+	require.Error(t, err)
+
+	c1.ack(1)
+	require.Eventually(t, func() bool { return len(s.transfers) == 0 }, time.Second, time.Millisecond*10)
+	c2.request(opRRQ, "boot.txt", "octet")
+	opcode, packet := c2.receive()
+	require.Equal(t, uint16(opDATA), opcode)
+	require.Equal(t, []byte("boot"), c2.download(defaultBlockSize, packet))
+}
+
+func TestNetASCIIReader(t *testing.T) {
+	data, err := io.ReadAll(iotest.OneByteReader(newNetASCIIReader(bytes.NewReader([]byte("a\nb\rc\n")))))
+	require.NoError(t, err)
+	require.Equal(t, []byte("a\r\nb\r\x00c\r\n"), data)
+}
+
+func TestCleanPath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"/pxelinux.0":            "pxelinux.0",
+		"pxelinux.cfg/default":   "pxelinux.cfg/default",
+		"\\boot\\bcd":            "boot/bcd",
+		"../../etc/passwd":       "etc/passwd",
+		"/efi//boot/../grub.efi": "efi/grub.efi",
+	} {
+		require.Equal(t, expected, CleanPath(name), name)
+	}
+}